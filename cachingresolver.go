@@ -0,0 +1,219 @@
+package netx
+
+import (
+	"container/list"
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/getlantern/errors"
+)
+
+// CacheOpts provides options for CachingResolver. It will use sensible defaults
+// for any missing options.
+type CacheOpts struct {
+	// TTL is how long to cache answers for when the upstream Resolver doesn't
+	// report a TTL.
+	TTL time.Duration
+	// NegativeTTL is how long to cache failed and empty lookups for.
+	NegativeTTL time.Duration
+	// MaxStale is how long after expiring an answer may still be served while it
+	// is refreshed in the background. If the refresh fails, the stale answer
+	// keeps being served until MaxStale elapses, and the refresh isn't retried
+	// for NegativeTTL.
+	MaxStale time.Duration
+	// MaxEntries is the maximum number of entries to cache. Once full, the least
+	// recently used entries are evicted.
+	MaxEntries int
+	// LookupTimeout bounds how long a single upstream lookup may take.
+	LookupTimeout time.Duration
+}
+
+func (opts *CacheOpts) ApplyDefaults() {
+	if opts.TTL <= 0 {
+		opts.TTL = 1 * time.Minute
+	}
+	if opts.NegativeTTL <= 0 {
+		opts.NegativeTTL = 5 * time.Second
+	}
+	if opts.MaxStale < 0 {
+		opts.MaxStale = 0
+	}
+	if opts.MaxEntries <= 0 {
+		opts.MaxEntries = 1000
+	}
+	if opts.LookupTimeout <= 0 {
+		opts.LookupTimeout = 30 * time.Second
+	}
+}
+
+// CachingResolver is a Resolver that caches the answers of an upstream
+// Resolver. Concurrent lookups of the same host share a single upstream
+// lookup.
+type CachingResolver struct {
+	upstream Resolver
+	opts     CacheOpts
+	now      func() time.Time
+	entries  map[string]*list.Element
+	lru      *list.List
+	flights  map[string]*flight
+	mx       sync.Mutex
+}
+
+type cacheEntry struct {
+	key     string
+	ips     []net.IP
+	err     error
+	expires time.Time
+	// retryAfter is when a stale entry whose refresh failed may be refreshed
+	// again.
+	retryAfter time.Time
+}
+
+type flight struct {
+	done chan struct{}
+	ips  []net.IP
+	ttl  time.Duration
+	err  error
+}
+
+// NewCachingResolver constructs a new CachingResolver in front of the given
+// upstream Resolver.
+func NewCachingResolver(upstream Resolver, opts *CacheOpts) *CachingResolver {
+	if opts == nil {
+		opts = &CacheOpts{}
+	}
+	o := *opts
+	o.ApplyDefaults()
+	return &CachingResolver{
+		upstream: upstream,
+		opts:     o,
+		now:      time.Now,
+		entries:  make(map[string]*list.Element),
+		lru:      list.New(),
+		flights:  make(map[string]*flight),
+	}
+}
+
+// EnableDNSCache puts a CachingResolver in front of the global Resolver.
+func EnableDNSCache(opts *CacheOpts) {
+	defaultNetx.EnableDNSCache(opts)
+}
+
+// EnableDNSCache puts a CachingResolver in front of this Netx's currently
+// configured Resolver.
+func (nx *Netx) EnableDNSCache(opts *CacheOpts) {
+	nx.OverrideResolver(NewCachingResolver(nx.getBaseResolver(), opts))
+}
+
+// LookupIP implements the method from the Resolver interface.
+func (r *CachingResolver) LookupIP(ctx context.Context, network, host string) ([]net.IP, error) {
+	ips, _, err := r.LookupIPTTL(ctx, network, host)
+	return ips, err
+}
+
+// LookupIPTTL implements the method from the TTLResolver interface. The
+// returned TTL is the time remaining until the answer expires.
+func (r *CachingResolver) LookupIPTTL(ctx context.Context, network, host string) ([]net.IP, time.Duration, error) {
+	key := network + "|" + host
+	now := r.now()
+
+	r.mx.Lock()
+	if el, found := r.entries[key]; found {
+		entry := el.Value.(*cacheEntry)
+		r.lru.MoveToFront(el)
+		if now.Before(entry.expires) {
+			r.mx.Unlock()
+			return entry.ips, entry.expires.Sub(now), entry.err
+		}
+		if entry.err == nil && now.Before(entry.expires.Add(r.opts.MaxStale)) {
+			// serve stale while revalidating
+			if !now.Before(entry.retryAfter) {
+				r.startFlight(key, network, host)
+			}
+			r.mx.Unlock()
+			return entry.ips, 0, nil
+		}
+	}
+	f := r.startFlight(key, network, host)
+	r.mx.Unlock()
+
+	select {
+	case <-f.done:
+		return f.ips, f.ttl, f.err
+	case <-ctx.Done():
+		return nil, 0, ctx.Err()
+	}
+}
+
+// startFlight starts an upstream lookup for the given key unless one is
+// already in flight. It must be called with r.mx held.
+func (r *CachingResolver) startFlight(key, network, host string) *flight {
+	if f, found := r.flights[key]; found {
+		return f
+	}
+	f := &flight{done: make(chan struct{})}
+	r.flights[key] = f
+	go func() {
+		// Use our own context so that one caller giving up doesn't fail the lookup
+		// for everyone else waiting on it.
+		ctx, cancel := context.WithTimeout(context.Background(), r.opts.LookupTimeout)
+		ips, ttl, err := lookupIPTTL(ctx, r.upstream, network, host)
+		cancel()
+		if err != nil || len(ips) == 0 {
+			ttl = r.opts.NegativeTTL
+		} else if ttl <= 0 {
+			ttl = r.opts.TTL
+		}
+		f.ips, f.ttl, f.err = ips, ttl, err
+
+		r.mx.Lock()
+		delete(r.flights, key)
+		r.store(key, ips, ttl, err)
+		r.mx.Unlock()
+		close(f.done)
+	}()
+	return f
+}
+
+// store records the result of a lookup. It must be called with r.mx held.
+func (r *CachingResolver) store(key string, ips []net.IP, ttl time.Duration, err error) {
+	now := r.now()
+	if el, found := r.entries[key]; found {
+		entry := el.Value.(*cacheEntry)
+		if err != nil && entry.err == nil && now.Before(entry.expires.Add(r.opts.MaxStale)) {
+			// keep serving the stale answer rather than the failure, but don't retry
+			// right away
+			entry.retryAfter = now.Add(ttl)
+			return
+		}
+		entry.ips, entry.err, entry.expires, entry.retryAfter = ips, err, now.Add(ttl), time.Time{}
+		r.lru.MoveToFront(el)
+		return
+	}
+	r.entries[key] = r.lru.PushFront(&cacheEntry{key: key, ips: ips, err: err, expires: now.Add(ttl)})
+	for r.lru.Len() > r.opts.MaxEntries {
+		oldest := r.lru.Back()
+		r.lru.Remove(oldest)
+		delete(r.entries, oldest.Value.(*cacheEntry).key)
+	}
+}
+
+// LookupSRV implements the method from the SRVResolver interface by passing the
+// lookup to the upstream Resolver without caching it.
+func (r *CachingResolver) LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	upstream, ok := r.upstream.(SRVResolver)
+	if !ok {
+		return "", nil, errors.New("Upstream resolver does not support SRV lookups")
+	}
+	return upstream.LookupSRV(ctx, service, proto, name)
+}
+
+// Flush removes all cached entries.
+func (r *CachingResolver) Flush() {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	r.entries = make(map[string]*list.Element)
+	r.lru.Init()
+}
@@ -0,0 +1,187 @@
+package netx
+
+import (
+	"context"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type countingResolver struct {
+	lookups int32
+	ips     []net.IP
+	err     error
+	release chan struct{}
+	mx      sync.Mutex
+}
+
+func (r *countingResolver) LookupIP(ctx context.Context, network, host string) ([]net.IP, error) {
+	atomic.AddInt32(&r.lookups, 1)
+	if r.release != nil {
+		<-r.release
+	}
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	return r.ips, r.err
+}
+
+func (r *countingResolver) set(ips []net.IP, err error) {
+	r.mx.Lock()
+	r.ips, r.err = ips, err
+	r.mx.Unlock()
+}
+
+func (r *countingResolver) count() int {
+	return int(atomic.LoadInt32(&r.lookups))
+}
+
+type fakeClock struct {
+	now time.Time
+	mx  sync.Mutex
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mx.Lock()
+	c.now = c.now.Add(d)
+	c.mx.Unlock()
+}
+
+func newTestCachingResolver(upstream Resolver, opts *CacheOpts) (*CachingResolver, *fakeClock) {
+	clock := &fakeClock{now: time.Now()}
+	r := NewCachingResolver(upstream, opts)
+	r.now = clock.Now
+	return r, clock
+}
+
+func TestCachingResolverTTL(t *testing.T) {
+	upstream := &countingResolver{ips: []net.IP{net.ParseIP("192.0.2.1")}}
+	r, clock := newTestCachingResolver(upstream, &CacheOpts{TTL: time.Minute})
+
+	for i := 0; i < 3; i++ {
+		ips, err := r.LookupIP(context.Background(), "ip", "example.com")
+		require.NoError(t, err)
+		assert.Equal(t, upstream.ips, ips)
+	}
+	assert.Equal(t, 1, upstream.count())
+
+	clock.Advance(2 * time.Minute)
+	_, err := r.LookupIP(context.Background(), "ip", "example.com")
+	require.NoError(t, err)
+	assert.Equal(t, 2, upstream.count(), "expired entry should be looked up again")
+}
+
+func TestCachingResolverNegative(t *testing.T) {
+	upstream := &countingResolver{err: assert.AnError}
+	r, clock := newTestCachingResolver(upstream, &CacheOpts{NegativeTTL: time.Second})
+
+	_, err := r.LookupIP(context.Background(), "ip", "example.com")
+	assert.Equal(t, assert.AnError, err)
+	_, err = r.LookupIP(context.Background(), "ip", "example.com")
+	assert.Equal(t, assert.AnError, err)
+	assert.Equal(t, 1, upstream.count())
+
+	clock.Advance(2 * time.Second)
+	upstream.set([]net.IP{net.ParseIP("192.0.2.1")}, nil)
+	ips, err := r.LookupIP(context.Background(), "ip", "example.com")
+	require.NoError(t, err)
+	assert.Len(t, ips, 1)
+}
+
+func TestCachingResolverServeStale(t *testing.T) {
+	upstream := &countingResolver{ips: []net.IP{net.ParseIP("192.0.2.1")}}
+	r, clock := newTestCachingResolver(upstream, &CacheOpts{TTL: time.Minute, MaxStale: time.Hour})
+
+	_, err := r.LookupIP(context.Background(), "ip", "example.com")
+	require.NoError(t, err)
+
+	upstream.set(nil, assert.AnError)
+	clock.Advance(2 * time.Minute)
+	for i := 0; i < 3; i++ {
+		ips, err := r.LookupIP(context.Background(), "ip", "example.com")
+		require.NoError(t, err, "stale answer should be served while upstream fails")
+		assert.Equal(t, []net.IP{net.ParseIP("192.0.2.1")}, ips)
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.True(t, upstream.count() > 1, "stale answer should have been revalidated")
+
+	clock.Advance(2 * time.Hour)
+	_, err = r.LookupIP(context.Background(), "ip", "example.com")
+	assert.Equal(t, assert.AnError, err, "answers beyond MaxStale should not be served")
+}
+
+func TestCachingResolverStaleRefreshBackoff(t *testing.T) {
+	upstream := &countingResolver{ips: []net.IP{net.ParseIP("192.0.2.1")}}
+	r, clock := newTestCachingResolver(upstream, &CacheOpts{TTL: time.Minute, NegativeTTL: 5 * time.Second, MaxStale: time.Hour})
+
+	_, err := r.LookupIP(context.Background(), "ip", "example.com")
+	require.NoError(t, err)
+	upstream.set(nil, assert.AnError)
+	clock.Advance(2 * time.Minute)
+
+	_, err = r.LookupIP(context.Background(), "ip", "example.com")
+	require.NoError(t, err)
+	// wait for the failed refresh to be recorded
+	time.Sleep(50 * time.Millisecond)
+	for i := 0; i < 50; i++ {
+		_, err = r.LookupIP(context.Background(), "ip", "example.com")
+		require.NoError(t, err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, 2, upstream.count(), "failed refresh shouldn't be retried before NegativeTTL")
+
+	clock.Advance(5 * time.Second)
+	_, err = r.LookupIP(context.Background(), "ip", "example.com")
+	require.NoError(t, err)
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, 3, upstream.count(), "refresh should be retried after NegativeTTL")
+}
+
+func TestCachingResolverLRU(t *testing.T) {
+	upstream := &countingResolver{ips: []net.IP{net.ParseIP("192.0.2.1")}}
+	r, _ := newTestCachingResolver(upstream, &CacheOpts{MaxEntries: 2})
+
+	for _, host := range []string{"a", "b", "a", "c"} {
+		_, err := r.LookupIP(context.Background(), "ip", host)
+		require.NoError(t, err)
+	}
+	assert.Equal(t, 3, upstream.count())
+
+	// b was least recently used and should have been evicted
+	_, err := r.LookupIP(context.Background(), "ip", "a")
+	require.NoError(t, err)
+	assert.Equal(t, 3, upstream.count())
+	_, err = r.LookupIP(context.Background(), "ip", "b")
+	require.NoError(t, err)
+	assert.Equal(t, 4, upstream.count())
+}
+
+func TestCachingResolverSingleflight(t *testing.T) {
+	upstream := &countingResolver{ips: []net.IP{net.ParseIP("192.0.2.1")}, release: make(chan struct{})}
+	r, _ := newTestCachingResolver(upstream, nil)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ips, err := r.LookupIP(context.Background(), "ip", "example.com")
+			assert.NoError(t, err)
+			assert.Len(t, ips, 1)
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(upstream.release)
+	wg.Wait()
+	assert.Equal(t, 1, upstream.count())
+}
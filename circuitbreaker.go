@@ -0,0 +1,256 @@
+package netx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultCircuitFailureThreshold is the default number of consecutive
+	// failures after which a circuit opens.
+	DefaultCircuitFailureThreshold = 5
+	// DefaultCircuitCooldown is the default time that a circuit stays open
+	// before allowing a trial dial.
+	DefaultCircuitCooldown = 30 * time.Second
+)
+
+// CircuitState is the state of the circuit for a destination.
+type CircuitState int
+
+const (
+	// CircuitClosed means that dials to the destination go ahead as usual.
+	CircuitClosed CircuitState = iota
+	// CircuitOpen means that dials to the destination fail fast with a
+	// CircuitOpenError.
+	CircuitOpen
+	// CircuitHalfOpen means that the cooldown has passed and a single trial dial
+	// is allowed, which closes the circuit if it succeeds and opens it again if
+	// it fails.
+	CircuitHalfOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	default:
+		return fmt.Sprintf("CircuitState(%d)", int(s))
+	}
+}
+
+// CircuitBreakerOpts provides options for EnableCircuitBreaker. It will use
+// sensible defaults for any missing options.
+type CircuitBreakerOpts struct {
+	// FailureThreshold is the number of consecutive failed dials to a
+	// destination after which its circuit opens.
+	FailureThreshold int
+	// Cooldown is how long a circuit stays open before allowing a trial dial.
+	Cooldown time.Duration
+}
+
+func (opts *CircuitBreakerOpts) ApplyDefaults() {
+	if opts.FailureThreshold <= 0 {
+		opts.FailureThreshold = DefaultCircuitFailureThreshold
+	}
+	if opts.Cooldown <= 0 {
+		opts.Cooldown = DefaultCircuitCooldown
+	}
+}
+
+// CircuitOpenError is the error wrapped by the DialError that's returned when
+// dialing a destination whose circuit is open, without trying to dial it.
+type CircuitOpenError struct {
+	// Addr is the destination.
+	Addr string
+	// RetryAfter is how long until a trial dial will be allowed.
+	RetryAfter time.Duration
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("circuit open for %v, retry after %v", e.Addr, e.RetryAfter)
+}
+
+// EnableCircuitBreaker enables a circuit breaker in the global DialContext.
+// See Netx.EnableCircuitBreaker.
+func EnableCircuitBreaker(opts *CircuitBreakerOpts) {
+	defaultNetx.EnableCircuitBreaker(opts)
+}
+
+// EnableCircuitBreaker enables a circuit breaker for each destination address
+// passed to DialContext. Once dials to an address have failed
+// opts.FailureThreshold times in a row, further dials to it fail right away
+// with a DialError wrapping a CircuitOpenError until opts.Cooldown has passed. Then one trial dial
+// is allowed, which decides whether the circuit closes or stays open for
+// another cooldown. Dials that are canceled by the caller don't count as
+// failures. Failures to a closed circuit are forgotten once opts.Cooldown has
+// passed without another one, as are half-open circuits that nobody has tried
+// for another opts.Cooldown, so the circuit breaker only keeps track of
+// destinations that have failed recently. Enabling the circuit breaker again
+// starts with all circuits closed.
+func (nx *Netx) EnableCircuitBreaker(opts *CircuitBreakerOpts) {
+	if opts == nil {
+		opts = &CircuitBreakerOpts{}
+	}
+	cb := &circuitBreaker{
+		opts:     *opts,
+		now:      time.Now,
+		circuits: make(map[string]*circuit),
+	}
+	cb.opts.ApplyDefaults()
+	nx.circuitBreaker.Store(circuitBreakerHolder{cb})
+}
+
+// DisableCircuitBreaker disables the circuit breaker in the global
+// DialContext.
+func DisableCircuitBreaker() {
+	defaultNetx.DisableCircuitBreaker()
+}
+
+// DisableCircuitBreaker disables the circuit breaker.
+func (nx *Netx) DisableCircuitBreaker() {
+	nx.circuitBreaker.Store(circuitBreakerHolder{})
+}
+
+// CircuitStateOf returns the state of the global circuit for addr.
+func CircuitStateOf(addr string) CircuitState {
+	return defaultNetx.CircuitStateOf(addr)
+}
+
+// CircuitStateOf returns the state of the circuit for addr. It's always
+// CircuitClosed if the circuit breaker is disabled.
+func (nx *Netx) CircuitStateOf(addr string) CircuitState {
+	cb := nx.getCircuitBreaker()
+	if cb == nil {
+		return CircuitClosed
+	}
+	return cb.state(addr)
+}
+
+// circuitBreakerHolder allows storing a nil *circuitBreaker in an
+// atomic.Value.
+type circuitBreakerHolder struct {
+	cb *circuitBreaker
+}
+
+func (nx *Netx) getCircuitBreaker() *circuitBreaker {
+	holder, _ := nx.circuitBreaker.Load().(circuitBreakerHolder)
+	return holder.cb
+}
+
+type circuit struct {
+	failures    int
+	lastFailure time.Time
+	openUntil   time.Time
+	trialActive bool
+}
+
+type circuitBreaker struct {
+	opts      CircuitBreakerOpts
+	now       func() time.Time
+	circuits  map[string]*circuit
+	nextPrune time.Time
+	mx        sync.Mutex
+}
+
+func (cb *circuitBreaker) state(addr string) CircuitState {
+	cb.mx.Lock()
+	defer cb.mx.Unlock()
+	c := cb.circuits[addr]
+	switch {
+	case c == nil || c.failures < cb.opts.FailureThreshold:
+		return CircuitClosed
+	case c.trialActive || !cb.now().Before(c.openUntil):
+		return CircuitHalfOpen
+	default:
+		return CircuitOpen
+	}
+}
+
+// allow returns an error if addr shouldn't be dialed right now. Otherwise, the
+// caller must dial and report the outcome using done.
+func (cb *circuitBreaker) allow(addr string) error {
+	cb.mx.Lock()
+	defer cb.mx.Unlock()
+	c := cb.circuits[addr]
+	if c == nil || c.failures < cb.opts.FailureThreshold {
+		return nil
+	}
+	now := cb.now()
+	if c.trialActive || now.Before(c.openUntil) {
+		retryAfter := c.openUntil.Sub(now)
+		if retryAfter < 0 {
+			retryAfter = 0
+		}
+		return &CircuitOpenError{Addr: addr, RetryAfter: retryAfter}
+	}
+	c.trialActive = true
+	return nil
+}
+
+func (cb *circuitBreaker) done(addr string, err error) {
+	cb.mx.Lock()
+	defer cb.mx.Unlock()
+	now := cb.now()
+	cb.pruneIfDue(now)
+	c := cb.circuits[addr]
+	if err == nil {
+		delete(cb.circuits, addr)
+		return
+	}
+	if errors.Is(err, context.Canceled) {
+		// the caller gave up, which says nothing about the destination
+		if c != nil {
+			c.trialActive = false
+		}
+		return
+	}
+	if c == nil {
+		c = &circuit{}
+		cb.circuits[addr] = c
+	} else if cb.stale(c, now) {
+		*c = circuit{}
+	}
+	trial := c.trialActive
+	c.trialActive = false
+	c.failures++
+	c.lastFailure = now
+	if trial || c.failures == cb.opts.FailureThreshold {
+		c.openUntil = now.Add(cb.opts.Cooldown)
+		log.Debugf("Opening circuit for %v after %d failures", addr, c.failures)
+	}
+}
+
+// stale returns true if c no longer needs to be tracked, either because it's
+// closed and hasn't failed for a cooldown, or because it's been half-open for
+// a whole cooldown without anyone trying it. It must be called with cb.mx held.
+func (cb *circuitBreaker) stale(c *circuit, now time.Time) bool {
+	if c.trialActive {
+		return false
+	}
+	if c.failures < cb.opts.FailureThreshold {
+		return now.Sub(c.lastFailure) >= cb.opts.Cooldown
+	}
+	return now.Sub(c.openUntil) >= cb.opts.Cooldown
+}
+
+// pruneIfDue removes stale circuits, at most once per cooldown so that the
+// cost of scanning them is spread over many dials. It must be called with
+// cb.mx held.
+func (cb *circuitBreaker) pruneIfDue(now time.Time) {
+	if now.Before(cb.nextPrune) {
+		return
+	}
+	cb.nextPrune = now.Add(cb.opts.Cooldown)
+	for addr, c := range cb.circuits {
+		if cb.stale(c, now) {
+			delete(cb.circuits, addr)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package netx
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	var dials int
+	var dialErr error
+	nx := New()
+	nx.OverrideDial(func(ctx context.Context, network, addr string) (net.Conn, error) {
+		dials++
+		if dialErr != nil {
+			return nil, dialErr
+		}
+		return &net.TCPConn{}, nil
+	})
+	nx.EnableCircuitBreaker(&CircuitBreakerOpts{FailureThreshold: 2, Cooldown: time.Minute})
+	clock := &fakeClock{now: time.Now()}
+	nx.getCircuitBreaker().now = clock.Now
+
+	dial := func() error {
+		_, err := nx.DialContext(context.Background(), "tcp", "127.0.0.1:1")
+		return err
+	}
+
+	dialErr = assert.AnError
+	assert.ErrorIs(t, dial(), assert.AnError)
+	assert.Equal(t, CircuitClosed, nx.CircuitStateOf("127.0.0.1:1"))
+	assert.ErrorIs(t, dial(), assert.AnError)
+	assert.Equal(t, CircuitOpen, nx.CircuitStateOf("127.0.0.1:1"))
+	assert.Equal(t, CircuitClosed, nx.CircuitStateOf("127.0.0.1:2"), "other destinations should be unaffected")
+
+	err := dial()
+	var wrapped *DialError
+	require.ErrorAs(t, err, &wrapped, "open circuit should be reported like any other dial failure")
+	assert.Empty(t, wrapped.Attempts)
+	var openErr *CircuitOpenError
+	require.ErrorAs(t, err, &openErr)
+	assert.Equal(t, "127.0.0.1:1", openErr.Addr)
+	assert.Equal(t, time.Minute, openErr.RetryAfter)
+	assert.Equal(t, 2, dials, "open circuit should fail fast")
+
+	// failed trial opens the circuit again
+	clock.Advance(time.Minute)
+	assert.Equal(t, CircuitHalfOpen, nx.CircuitStateOf("127.0.0.1:1"))
+	assert.ErrorIs(t, dial(), assert.AnError)
+	assert.Equal(t, 3, dials)
+	assert.Equal(t, CircuitOpen, nx.CircuitStateOf("127.0.0.1:1"))
+	require.ErrorAs(t, dial(), &openErr)
+
+	// successful trial closes it
+	clock.Advance(time.Minute)
+	dialErr = nil
+	assert.NoError(t, dial())
+	assert.Equal(t, CircuitClosed, nx.CircuitStateOf("127.0.0.1:1"))
+	assert.NoError(t, dial())
+	assert.Equal(t, 5, dials)
+}
+
+func TestCircuitBreakerIgnoresCanceled(t *testing.T) {
+	nx := New()
+	nx.OverrideDial(func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return nil, context.Canceled
+	})
+	nx.EnableCircuitBreaker(&CircuitBreakerOpts{FailureThreshold: 1})
+	_, err := nx.DialContext(context.Background(), "tcp", "127.0.0.1:1")
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, CircuitClosed, nx.CircuitStateOf("127.0.0.1:1"))
+
+	nx.DisableCircuitBreaker()
+	assert.Equal(t, CircuitClosed, nx.CircuitStateOf("127.0.0.1:1"))
+}
+
+func TestCircuitBreakerPrunesStaleCircuits(t *testing.T) {
+	nx := New()
+	nx.OverrideDial(func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return nil, assert.AnError
+	})
+	nx.EnableCircuitBreaker(&CircuitBreakerOpts{FailureThreshold: 2, Cooldown: time.Minute})
+	cb := nx.getCircuitBreaker()
+	clock := &fakeClock{now: time.Now()}
+	cb.now = clock.Now
+
+	dial := func(addr string) {
+		_, _ = nx.DialContext(context.Background(), "tcp", addr)
+	}
+
+	dial("127.0.0.1:1")
+	dial("127.0.0.1:1")
+	for i := 2; i < 100; i++ {
+		dial(fmt.Sprintf("127.0.0.1:%d", i))
+	}
+	assert.Len(t, cb.circuits, 99)
+
+	// failures older than the cooldown are forgotten
+	clock.Advance(time.Minute)
+	dial("127.0.0.1:2")
+	assert.Equal(t, CircuitClosed, nx.CircuitStateOf("127.0.0.1:2"))
+	assert.Equal(t, CircuitHalfOpen, nx.CircuitStateOf("127.0.0.1:1"), "half-open circuit should be kept for another cooldown")
+	assert.Len(t, cb.circuits, 2)
+
+	clock.Advance(time.Minute)
+	dial("127.0.0.1:3")
+	assert.Len(t, cb.circuits, 1, "untried half-open and closed circuits should be pruned")
+	assert.Equal(t, CircuitClosed, nx.CircuitStateOf("127.0.0.1:1"))
+}
@@ -1,19 +1,56 @@
 package netx
 
 import (
+	"context"
+	"fmt"
 	"io"
 	"net"
 	"runtime/debug"
+	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/getlantern/errors"
 )
 
+// DefaultDrainGracePeriod is the default CopyOpts.DrainGracePeriod.
+const DefaultDrainGracePeriod = 1 * time.Second
+
 var (
-	copyTimeout = 1 * time.Second
+	// aLongTimeAgo is a deadline in the past, used to unblock pending reads and
+	// writes immediately.
+	aLongTimeAgo = time.Unix(1, 0)
 )
 
+// DrainMode determines what BidiCopy does with the other direction once one
+// direction finishes.
+type DrainMode int
+
+const (
+	// DrainWithGracePeriod keeps copying the other direction until no data has
+	// been read for CopyOpts.DrainGracePeriod. This is the default.
+	DrainWithGracePeriod DrainMode = iota
+	// DrainUntilEOF keeps copying the other direction until it finishes on its
+	// own, however long that takes.
+	DrainUntilEOF
+	// DrainNone stops the other direction immediately, discarding anything it
+	// hasn't read yet.
+	DrainNone
+)
+
+func (m DrainMode) String() string {
+	switch m {
+	case DrainWithGracePeriod:
+		return "grace period"
+	case DrainUntilEOF:
+		return "until EOF"
+	case DrainNone:
+		return "none"
+	default:
+		return fmt.Sprintf("DrainMode(%d)", int(m))
+	}
+}
+
 // CopyOpts provides options for BidiCopy. It will use sensible defaults for any missing options
 type CopyOpts struct {
 	BufIn          []byte
@@ -21,6 +58,59 @@ type CopyOpts struct {
 	OnOut          func(int)
 	OnIn           func(int)
 	StartGoroutine func(func())
+	// IdleTimeout, if positive, ends both directions with an IdleTimeoutError
+	// once no data has been copied in either direction for this long. Time
+	// spent waiting on OutLimiter or InLimiter doesn't count as idle.
+	IdleTimeout time.Duration
+	// MaxDuration, if positive, ends both directions with a MaxDurationError
+	// once they've been copying for this long in total.
+	MaxDuration time.Duration
+	// OutLimiter, if set, limits the rate at which data is copied to out, i.e.
+	// read from in and written to out. It's waited on before each write and can
+	// be shared between copies to limit their combined rate.
+	OutLimiter *RateLimiter
+	// InLimiter is like OutLimiter for data copied to in.
+	InLimiter *RateLimiter
+	// HalfClose, if true, makes a direction that reaches EOF close the write
+	// side of its destination, so the peer sees EOF too, while the other
+	// direction keeps copying until it finishes on its own. This requires the
+	// destination or one of the conns it wraps to have a CloseWrite method,
+	// like *net.TCPConn does; otherwise the other direction is drained as usual.
+	HalfClose bool
+	// Drain determines what happens to the other direction once one direction
+	// finishes, see DrainMode.
+	Drain DrainMode
+	// DrainGracePeriod is how long DrainWithGracePeriod waits for more data.
+	// Defaults to DefaultDrainGracePeriod.
+	DrainGracePeriod time.Duration
+}
+
+// closeWriter is a conn whose write side can be closed independently, like
+// *net.TCPConn.
+type closeWriter interface {
+	CloseWrite() error
+}
+
+// IdleTimeoutError is returned by BidiCopy when no data was copied in either
+// direction for CopyOpts.IdleTimeout.
+type IdleTimeoutError struct {
+	// IdleTimeout is the configured idle timeout.
+	IdleTimeout time.Duration
+}
+
+func (e *IdleTimeoutError) Error() string {
+	return fmt.Sprintf("no data copied for %v", e.IdleTimeout)
+}
+
+// MaxDurationError is returned by BidiCopy when copying took longer than
+// CopyOpts.MaxDuration.
+type MaxDurationError struct {
+	// MaxDuration is the configured maximum duration.
+	MaxDuration time.Duration
+}
+
+func (e *MaxDurationError) Error() string {
+	return fmt.Sprintf("copying exceeded maximum duration of %v", e.MaxDuration)
 }
 
 func (opts *CopyOpts) ApplyDefaults() {
@@ -39,6 +129,9 @@ func (opts *CopyOpts) ApplyDefaults() {
 	if opts.StartGoroutine == nil {
 		opts.StartGoroutine = basicStartGoroutine
 	}
+	if opts.DrainGracePeriod <= 0 {
+		opts.DrainGracePeriod = DefaultDrainGracePeriod
+	}
 }
 
 // BidiCopy copies between in and out in both directions using the specified
@@ -53,21 +146,193 @@ func BidiCopy(out net.Conn, in net.Conn, bufOut []byte, bufIn []byte) (outErr er
 
 // BidiCopyWithOpts is like the original BidiCopy but providing more options and returning channels for reading the errors rather than the errors themselves.
 func BidiCopyWithOpts(out net.Conn, in net.Conn, opts *CopyOpts) (outErr <-chan error, inErr <-chan error) {
+	return BidiCopyContext(context.Background(), out, in, opts)
+}
+
+// BidiCopyContext is like BidiCopyWithOpts but stops copying when ctx is done.
+// Both directions are then unblocked by setting the conns' deadlines in the
+// past, and each direction that hadn't already finished reports ctx's error,
+// like context.Canceled or context.DeadlineExceeded, instead of the resulting
+// I/O error. Either way in and out are left open, so callers remain
+// responsible for closing them, and once cancelled they keep deadlines in the
+// past. Reaching opts.IdleTimeout or opts.MaxDuration stops copying the same
+// way, reporting an IdleTimeoutError or MaxDurationError respectively.
+func BidiCopyContext(ctx context.Context, out net.Conn, in net.Conn, opts *CopyOpts) (outErr <-chan error, inErr <-chan error) {
 	opts.ApplyDefaults()
-	stop := uint32(0)
+	s := newCopySession(ctx, out, in, opts)
 	outErrCh := make(chan error, 1)
 	inErrCh := make(chan error, 1)
-	go doCopy(out, in, opts.BufIn, outErrCh, &stop, opts.OnOut, opts.StartGoroutine)
-	go doCopy(in, out, opts.BufOut, inErrCh, &stop, opts.OnIn, opts.StartGoroutine)
+	go doCopy(s, out, in, opts.BufIn, outErrCh, opts.OnOut, opts.OutLimiter)
+	go doCopy(s, in, out, opts.BufOut, inErrCh, opts.OnIn, opts.InLimiter)
 	return outErrCh, inErrCh
 }
 
+// copySession is the state shared by both directions of a BidiCopy.
+type copySession struct {
+	ctx          context.Context
+	cancel       context.CancelCauseFunc
+	stopAbort    func() bool
+	maxTimer     *time.Timer
+	idleTimer    *time.Timer
+	conns        [2]net.Conn
+	idleTimeout  time.Duration
+	halfClose    bool
+	drain        DrainMode
+	gracePeriod  time.Duration
+	lastActivity int64
+	throttled    int32
+	stop         uint32
+	mx           sync.Mutex
+	aborted      bool
+	finished     int
+}
+
+func newCopySession(ctx context.Context, out net.Conn, in net.Conn, opts *CopyOpts) *copySession {
+	s := &copySession{
+		conns:       [2]net.Conn{out, in},
+		idleTimeout: opts.IdleTimeout,
+		halfClose:   opts.HalfClose,
+		drain:       opts.Drain,
+		gracePeriod: opts.DrainGracePeriod,
+	}
+	s.ctx, s.cancel = context.WithCancelCause(ctx)
+	s.stopAbort = context.AfterFunc(s.ctx, s.abort)
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	if opts.MaxDuration > 0 {
+		s.maxTimer = time.AfterFunc(opts.MaxDuration, func() {
+			s.cancel(&MaxDurationError{MaxDuration: opts.MaxDuration})
+		})
+	}
+	if s.idleTimeout > 0 {
+		s.markActive()
+		s.idleTimer = time.AfterFunc(s.idleTimeout, s.checkIdle)
+	}
+	return s
+}
+
+// markActive records that data was just copied.
+func (s *copySession) markActive() {
+	atomic.StoreInt64(&s.lastActivity, time.Now().UnixNano())
+}
+
+// waitLimiter waits for limiter to allow copying n bytes. The session isn't
+// considered idle meanwhile.
+func (s *copySession) waitLimiter(limiter *RateLimiter, n int) error {
+	atomic.AddInt32(&s.throttled, 1)
+	err := limiter.WaitN(s.ctx, n)
+	if s.idleTimeout > 0 {
+		s.markActive()
+	}
+	atomic.AddInt32(&s.throttled, -1)
+	return err
+}
+
+// checkIdle aborts the session if it has been idle for too long, or otherwise
+// checks again when it would be.
+func (s *copySession) checkIdle() {
+	if s.ctx.Err() != nil {
+		return
+	}
+	idle := time.Since(time.Unix(0, atomic.LoadInt64(&s.lastActivity)))
+	if atomic.LoadInt32(&s.throttled) > 0 {
+		// data is being copied, just slowly
+		idle = 0
+	}
+	if idle >= s.idleTimeout {
+		s.cancel(&IdleTimeoutError{IdleTimeout: s.idleTimeout})
+		return
+	}
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	if s.finished < 2 {
+		s.idleTimer.Reset(s.idleTimeout - idle)
+	}
+}
+
+// abort unblocks both directions, unless they've both finished already.
+func (s *copySession) abort() {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	if s.finished == 2 {
+		return
+	}
+	s.aborted = true
+	atomic.StoreUint32(&s.stop, 1)
+	for _, conn := range s.conns {
+		conn.SetDeadline(aLongTimeAgo)
+	}
+}
+
+// abortErr returns the reason the session was aborted, or nil if it wasn't.
+func (s *copySession) abortErr() error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	if !s.aborted {
+		return nil
+	}
+	return context.Cause(s.ctx)
+}
+
+// setReadDeadline sets conn's read deadline unless the session has been
+// aborted, in which case the deadline has to stay in the past.
+func (s *copySession) setReadDeadline(conn net.Conn, t time.Time) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	if !s.aborted {
+		conn.SetReadDeadline(t)
+	}
+}
+
+// drainDeadline returns the read deadline for a direction that's draining
+// because the other one finished.
+func (s *copySession) drainDeadline() time.Time {
+	if s.drain == DrainNone {
+		return aLongTimeAgo
+	}
+	return time.Now().Add(s.gracePeriod)
+}
+
+// errorFor returns the reason the session was aborted if it was, since that's
+// what caused err, or else err itself.
+func (s *copySession) errorFor(err error) error {
+	if abortErr := s.abortErr(); abortErr != nil {
+		return abortErr
+	}
+	return err
+}
+
+// finish records that one direction has finished.
+func (s *copySession) finish() {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	s.finished++
+	if s.finished == 2 {
+		s.stopAbort()
+		if s.maxTimer != nil {
+			s.maxTimer.Stop()
+		}
+		if s.idleTimer != nil {
+			s.idleTimer.Stop()
+		}
+		s.cancel(nil)
+	}
+}
+
 // doCopy is based on io.copyBuffer
-func doCopy(dst net.Conn, src net.Conn, buf []byte, errCh chan error, stop *uint32, cb func(int), startGoroutine func(func())) {
+func doCopy(s *copySession, dst net.Conn, src net.Conn, buf []byte, errCh chan error, cb func(int), limiter *RateLimiter) {
 	var err error
+	eof := false
 	defer func() {
-		atomic.StoreUint32(stop, 1)
-		dst.SetReadDeadline(time.Now().Add(copyTimeout))
+		halfClosed := false
+		if eof && s.halfClose {
+			halfClosed, err = closeWrite(dst)
+		}
+		if !halfClosed && s.drain != DrainUntilEOF {
+			atomic.StoreUint32(&s.stop, 1)
+			s.setReadDeadline(dst, s.drainDeadline())
+		}
+		s.finish()
 		errCh <- err
 		close(errCh)
 	}()
@@ -80,15 +345,24 @@ func doCopy(dst net.Conn, src net.Conn, buf []byte, errCh chan error, stop *uint
 	}()
 
 	for {
-		stopping := atomic.LoadUint32(stop) == 1
+		stopping := atomic.LoadUint32(&s.stop) == 1
 		if stopping {
-			src.SetReadDeadline(time.Now().Add(copyTimeout))
+			s.setReadDeadline(src, s.drainDeadline())
 		}
 		nr, er := src.Read(buf)
 		if nr > 0 {
+			if s.idleTimeout > 0 {
+				s.markActive()
+			}
+			if limiter != nil {
+				if ew := s.waitLimiter(limiter, nr); ew != nil {
+					err = context.Cause(s.ctx)
+					return
+				}
+			}
 			nw, ew := dst.Write(buf[0:nr])
 			if ew != nil {
-				err = ew
+				err = s.errorFor(ew)
 				return
 			}
 			if nw != nr {
@@ -98,9 +372,14 @@ func doCopy(dst net.Conn, src net.Conn, buf []byte, errCh chan error, stop *uint
 			cb(nw)
 		}
 		if er == io.EOF {
+			eof = true
 			return
 		}
 		if er != nil {
+			if abortErr := s.abortErr(); abortErr != nil {
+				err = abortErr
+				return
+			}
 			if IsTimeout(er) {
 				if stopping {
 					return
@@ -113,6 +392,23 @@ func doCopy(dst net.Conn, src net.Conn, buf []byte, errCh chan error, stop *uint
 	}
 }
 
+// closeWrite closes the write side of the first conn in the chain wrapped by
+// conn that supports it, returning false if none does.
+func closeWrite(conn net.Conn) (bool, error) {
+	var cw closeWriter
+	WalkWrapped(conn, func(wrapped net.Conn) bool {
+		cw, _ = wrapped.(closeWriter)
+		return cw == nil
+	})
+	if cw == nil {
+		return false, nil
+	}
+	if err := cw.CloseWrite(); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
 // IsTimeout indicates whether the given error is a network timeout error
 func IsTimeout(err error) bool {
 	if nerr, ok := err.(net.Error); ok && nerr.Timeout() {
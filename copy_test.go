@@ -1,9 +1,11 @@
 package netx
 
 import (
+	"context"
 	"io"
 	"io/ioutil"
 	"net"
+	"os"
 	"strings"
 	"sync"
 	"testing"
@@ -16,11 +18,6 @@ import (
 )
 
 func TestSimulatedProxy(t *testing.T) {
-	originalCopyTimeout := copyTimeout
-	copyTimeout = 5 * time.Millisecond
-	defer func() {
-		copyTimeout = originalCopyTimeout
-	}()
 	data := make([]byte, 30000000)
 	for i := 0; i < len(data); i++ {
 		data[i] = 5
@@ -78,9 +75,13 @@ func TestSimulatedProxy(t *testing.T) {
 		}
 		defer out.Close()
 
-		errOut, errIn := BidiCopy(out, in, make([]byte, 32768), make([]byte, 32768))
-		assert.NoError(t, errOut, "Error copying to server")
-		assert.NoError(t, errIn, "Error copying to client")
+		errOutCh, errInCh := BidiCopyWithOpts(out, in, &CopyOpts{
+			BufIn:            make([]byte, 32768),
+			BufOut:           make([]byte, 32768),
+			DrainGracePeriod: 5 * time.Millisecond,
+		})
+		assert.NoError(t, <-errOutCh, "Error copying to server")
+		assert.NoError(t, <-errInCh, "Error copying to client")
 		wg.Done()
 	}()
 
@@ -135,10 +136,10 @@ func TestWriteError(t *testing.T) {
 	dst.Close()
 
 	errCh := make(chan error, 1)
-	stop := uint32(0)
 	buf := make([]byte, 1000)
 	nw := 0
-	doCopy(dst, src, buf, errCh, &stop, func(n int) { nw += n }, basicStartGoroutine)
+	s := newCopySession(context.Background(), dst, src, &CopyOpts{})
+	doCopy(s, dst, src, buf, errCh, func(n int) { nw += n }, nil)
 	reportedErr := <-errCh
 	assert.Contains(t, reportedErr.Error(), "use of closed network connection")
 	assert.Zero(t, nw, "Shouldn't have written any bytes")
@@ -166,15 +167,218 @@ func TestReadError(t *testing.T) {
 	src.Close()
 
 	errCh := make(chan error, 1)
-	stop := uint32(0)
 	buf := make([]byte, 1000)
 	nw := 0
-	doCopy(dst, src, buf, errCh, &stop, func(n int) { nw += n }, basicStartGoroutine)
+	s := newCopySession(context.Background(), dst, src, &CopyOpts{})
+	doCopy(s, dst, src, buf, errCh, func(n int) { nw += n }, nil)
 	reportedErr := <-errCh
 	assert.Contains(t, reportedErr.Error(), "use of closed network connection")
 	assert.Zero(t, nw, "Shouldn't have written any bytes")
 }
 
+func TestBidiCopyContext(t *testing.T) {
+	out, outPeer := net.Pipe()
+	defer outPeer.Close()
+	in, inPeer := net.Pipe()
+	defer inPeer.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	outErrCh, inErrCh := BidiCopyContext(ctx, out, in, &CopyOpts{})
+	go func() {
+		inPeer.Write([]byte("hello"))
+	}()
+	b := make([]byte, 5)
+	_, err := io.ReadFull(outPeer, b)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(b))
+
+	cancel()
+	assert.Equal(t, context.Canceled, <-outErrCh)
+	assert.Equal(t, context.Canceled, <-inErrCh)
+
+	// the conns are still open but their deadlines have passed
+	_, err = out.Read(b)
+	assert.ErrorIs(t, err, os.ErrDeadlineExceeded)
+	require.NoError(t, in.SetDeadline(time.Time{}))
+	go func() {
+		inPeer.Write([]byte("again"))
+	}()
+	_, err = io.ReadFull(in, b)
+	require.NoError(t, err)
+	assert.Equal(t, "again", string(b))
+	out.Close()
+	in.Close()
+}
+
+func TestBidiCopyContextDeadline(t *testing.T) {
+	out, outPeer := net.Pipe()
+	defer out.Close()
+	defer outPeer.Close()
+	in, inPeer := net.Pipe()
+	defer in.Close()
+	defer inPeer.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	outErrCh, inErrCh := BidiCopyContext(ctx, out, in, &CopyOpts{})
+	assert.Equal(t, context.DeadlineExceeded, <-outErrCh)
+	assert.Equal(t, context.DeadlineExceeded, <-inErrCh)
+}
+
+func TestBidiCopyContextIOError(t *testing.T) {
+	out, outPeer := net.Pipe()
+	defer outPeer.Close()
+	in, inPeer := net.Pipe()
+	defer in.Close()
+	defer inPeer.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	outErrCh, inErrCh := BidiCopyContext(ctx, out, in, &CopyOpts{})
+	out.Close()
+	assert.Equal(t, io.ErrClosedPipe, <-inErrCh, "I/O errors before cancellation should be reported as is")
+	cancel()
+	assert.Equal(t, context.Canceled, <-outErrCh)
+}
+
+func TestBidiCopyIdleTimeout(t *testing.T) {
+	out, outPeer := net.Pipe()
+	defer out.Close()
+	defer outPeer.Close()
+	in, inPeer := net.Pipe()
+	defer in.Close()
+	defer inPeer.Close()
+
+	start := time.Now()
+	outErrCh, inErrCh := BidiCopyWithOpts(out, in, &CopyOpts{IdleTimeout: 100 * time.Millisecond})
+	go io.Copy(io.Discard, outPeer)
+	// keep data flowing for a while, which shouldn't time out
+	for i := 0; i < 5; i++ {
+		_, err := inPeer.Write([]byte("ping"))
+		require.NoError(t, err)
+		time.Sleep(40 * time.Millisecond)
+	}
+
+	var idleErr *IdleTimeoutError
+	require.ErrorAs(t, <-outErrCh, &idleErr)
+	assert.Equal(t, 100*time.Millisecond, idleErr.IdleTimeout)
+	require.ErrorAs(t, <-inErrCh, &idleErr)
+	assert.True(t, time.Since(start) > 200*time.Millisecond, "shouldn't have timed out while data was flowing")
+}
+
+func TestBidiCopyMaxDuration(t *testing.T) {
+	out, outPeer := net.Pipe()
+	defer out.Close()
+	defer outPeer.Close()
+	in, inPeer := net.Pipe()
+	defer in.Close()
+	defer inPeer.Close()
+
+	outErrCh, inErrCh := BidiCopyWithOpts(out, in, &CopyOpts{IdleTimeout: time.Minute, MaxDuration: 20 * time.Millisecond})
+	var maxErr *MaxDurationError
+	require.ErrorAs(t, <-outErrCh, &maxErr)
+	assert.Equal(t, 20*time.Millisecond, maxErr.MaxDuration)
+	require.ErrorAs(t, <-inErrCh, &maxErr)
+}
+
+func TestBidiCopyHalfClose(t *testing.T) {
+	// Start server that reads the whole request before responding
+	ls, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+	defer ls.Close()
+	go func() {
+		conn, acceptErr := ls.Accept()
+		if !assert.NoError(t, acceptErr, "Unable to accept connection") {
+			return
+		}
+		defer conn.Close()
+		request, _ := io.ReadAll(conn)
+		// respond later than the grace period for the other direction
+		time.Sleep(100 * time.Millisecond)
+		conn.Write([]byte("response to " + string(request)))
+	}()
+
+	lp, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+	defer lp.Close()
+	errCh := make(chan error, 2)
+	go func() {
+		in, acceptErr := lp.Accept()
+		if !assert.NoError(t, acceptErr, "Proxy unable to accept") {
+			return
+		}
+		defer in.Close()
+		out, dialErr := net.Dial("tcp", ls.Addr().String())
+		if !assert.NoError(t, dialErr, "Proxy unable to dial server") {
+			return
+		}
+		defer out.Close()
+		outErrCh, inErrCh := BidiCopyWithOpts(&connWrap{Conn: out, wrapped: out}, in, &CopyOpts{HalfClose: true, DrainGracePeriod: 10 * time.Millisecond})
+		errCh <- <-outErrCh
+		errCh <- <-inErrCh
+	}()
+
+	conn, err := net.Dial("tcp", lp.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("request"))
+	require.NoError(t, err)
+	require.NoError(t, conn.(*net.TCPConn).CloseWrite())
+	response, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Equal(t, "response to request", string(response))
+	assert.NoError(t, <-errCh)
+	assert.NoError(t, <-errCh)
+}
+
+func TestBidiCopyDrainUntilEOF(t *testing.T) {
+	client, in := tcpPair(t)
+	defer client.Close()
+	defer in.Close()
+	out, server := tcpPair(t)
+	defer out.Close()
+	defer server.Close()
+
+	outErrCh, inErrCh := BidiCopyWithOpts(out, in, &CopyOpts{Drain: DrainUntilEOF, DrainGracePeriod: 10 * time.Millisecond})
+	require.NoError(t, client.(*net.TCPConn).CloseWrite())
+	assert.NoError(t, <-outErrCh)
+	time.Sleep(50 * time.Millisecond)
+	_, err := server.Write([]byte("late"))
+	require.NoError(t, err)
+	response := make([]byte, 4)
+	_, err = io.ReadFull(client, response)
+	require.NoError(t, err)
+	assert.Equal(t, "late", string(response), "should have kept copying past the grace period")
+	server.Close()
+	assert.NoError(t, <-inErrCh)
+}
+
+func TestBidiCopyDrainNone(t *testing.T) {
+	out, outPeer := net.Pipe()
+	defer out.Close()
+	defer outPeer.Close()
+	in, inPeer := net.Pipe()
+	defer in.Close()
+
+	start := time.Now()
+	outErrCh, inErrCh := BidiCopyWithOpts(out, in, &CopyOpts{Drain: DrainNone, DrainGracePeriod: time.Minute})
+	inPeer.Close()
+	assert.NoError(t, <-outErrCh)
+	assert.NoError(t, <-inErrCh)
+	assert.True(t, time.Since(start) < time.Second, "shouldn't have waited for the grace period")
+}
+
+// tcpPair returns both ends of a loopback TCP connection.
+func tcpPair(t *testing.T) (net.Conn, net.Conn) {
+	l, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+	defer l.Close()
+	client, err := net.Dial("tcp", l.Addr().String())
+	require.NoError(t, err)
+	server, err := l.Accept()
+	require.NoError(t, err)
+	return client, server
+}
+
 func TestPanicOnCopy(t *testing.T) {
 	outErr, inErr := BidiCopy(newPanickingConn(), newPanickingConn(), make([]byte, 8192), make([]byte, 8192))
 	require.Error(t, outErr)
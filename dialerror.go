@@ -0,0 +1,106 @@
+package netx
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// DialAttempt records a single attempt to dial an address.
+type DialAttempt struct {
+	// Network is the network that was dialed.
+	Network string
+	// OriginalAddr is the address that the caller asked to dial.
+	OriginalAddr string
+	// Addr is the address that was actually dialed. It differs from
+	// OriginalAddr if it was resolved or synthesized from OriginalAddr.
+	Addr string
+	// Synthesized indicates that Addr was synthesized using a NAT64 prefix.
+	Synthesized bool
+	// Duration is how long the attempt took.
+	Duration time.Duration
+	// Err is the error from the attempt, or nil if it succeeded.
+	Err error
+	// Timeout indicates whether Err was a timeout as determined by IsTimeout.
+	Timeout bool
+	// Retry is 0 for attempts made by the first try and counts up for each
+	// retry made according to the RetryPolicy.
+	Retry int
+}
+
+// DialError is returned by netx when dialing fails. It records every address
+// that was tried.
+type DialError struct {
+	// Network is the network that the caller asked to dial.
+	Network string
+	// Addr is the address that the caller asked to dial.
+	Addr string
+	// Attempts lists every attempt in the order in which they finished.
+	Attempts []DialAttempt
+	// Err is the error that kept any address from being tried, like a failure
+	// to resolve Addr. It's only set if there are no Attempts.
+	Err error
+}
+
+// Error implements the error interface. With a single attempt, this is just
+// that attempt's error.
+func (e *DialError) Error() string {
+	switch len(e.Attempts) {
+	case 0:
+		if e.Err != nil {
+			return fmt.Sprintf("unable to dial %v (%v): %v", e.Addr, e.Network, e.Err)
+		}
+		return fmt.Sprintf("unable to dial %v (%v): no addresses to try", e.Addr, e.Network)
+	case 1:
+		return e.Attempts[0].Err.Error()
+	}
+	errs := make([]string, 0, len(e.Attempts))
+	for _, attempt := range e.Attempts {
+		errs = append(errs, fmt.Sprintf("%v: %v", attempt.Addr, attempt.Err))
+	}
+	return fmt.Sprintf("unable to dial %v (%v) after %d attempts: %v", e.Addr, e.Network, len(e.Attempts), strings.Join(errs, "; "))
+}
+
+// Unwrap returns the errors from all attempts, or Err if there weren't any,
+// for use with errors.Is and errors.As.
+func (e *DialError) Unwrap() []error {
+	if len(e.Attempts) == 0 && e.Err != nil {
+		return []error{e.Err}
+	}
+	errs := make([]error, 0, len(e.Attempts))
+	for _, attempt := range e.Attempts {
+		errs = append(errs, attempt.Err)
+	}
+	return errs
+}
+
+// Timeout implements the method from net.Error. It's true if the last attempt
+// timed out, so IsTimeout works on DialErrors.
+func (e *DialError) Timeout() bool {
+	if len(e.Attempts) == 0 {
+		return e.Err != nil && IsTimeout(e.Err)
+	}
+	return e.Attempts[len(e.Attempts)-1].Timeout
+}
+
+// Temporary implements the method from net.Error.
+func (e *DialError) Temporary() bool {
+	return false
+}
+
+// dialAndRecord dials addr using dialer and records the attempt.
+func dialAndRecord(ctx context.Context, dialer DialFunc, network string, originalAddr string, addr string, synthesized bool) (net.Conn, DialAttempt) {
+	start := time.Now()
+	conn, err := dialer(ctx, network, addr)
+	return conn, DialAttempt{
+		Network:      network,
+		OriginalAddr: originalAddr,
+		Addr:         addr,
+		Synthesized:  synthesized,
+		Duration:     time.Since(start),
+		Err:          err,
+		Timeout:      err != nil && IsTimeout(err),
+	}
+}
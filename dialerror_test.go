@@ -0,0 +1,94 @@
+package netx
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDialErrorRecordsAllAttempts(t *testing.T) {
+	nx := New()
+	prefix, _ := ParseNAT64Prefix("64:ff9b::/96")
+	nx.SetNAT64Prefix(prefix)
+	nx.OverrideDial(func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if addr == "[64:ff9b::5db8:d822]:443" {
+			return nil, &net.OpError{Op: "dial", Net: network, Err: &timeouterror{}}
+		}
+		return nil, &net.OpError{Op: "dial", Net: network, Err: assert.AnError}
+	})
+
+	_, err := nx.DialContext(context.Background(), "tcp", "93.184.216.34:443")
+	require.Error(t, err)
+	var dialErr *DialError
+	require.True(t, errors.As(err, &dialErr))
+	require.Len(t, dialErr.Attempts, 2)
+
+	synthesized := dialErr.Attempts[0]
+	assert.Equal(t, "93.184.216.34:443", synthesized.OriginalAddr)
+	assert.Equal(t, "[64:ff9b::5db8:d822]:443", synthesized.Addr)
+	assert.True(t, synthesized.Synthesized)
+	assert.True(t, synthesized.Timeout)
+	assert.Equal(t, "tcp", synthesized.Network)
+
+	original := dialErr.Attempts[1]
+	assert.Equal(t, "93.184.216.34:443", original.Addr)
+	assert.False(t, original.Synthesized)
+	assert.False(t, original.Timeout)
+
+	assert.ErrorIs(t, err, assert.AnError, "both attempts' errors should be reachable")
+	var opErr *net.OpError
+	assert.True(t, errors.As(err, &opErr))
+	assert.False(t, IsTimeout(err), "last attempt didn't time out")
+	assert.Contains(t, err.Error(), "after 2 attempts")
+}
+
+func TestDialErrorSingleAttempt(t *testing.T) {
+	nx := New()
+	nx.OverrideDial(func(ctx context.Context, network, addr string) (net.Conn, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+
+	_, err := nx.DialTimeout("tcp", "192.0.2.1:443", 10*time.Millisecond)
+	require.Error(t, err)
+	assert.Equal(t, context.DeadlineExceeded.Error(), err.Error())
+	assert.True(t, IsTimeout(err))
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	require.Len(t, err.(*DialError).Attempts, 1)
+}
+
+func TestDialErrorWithoutAttempts(t *testing.T) {
+	nx := New()
+	nx.OverrideResolveIPs(func(host string) ([]net.IP, error) {
+		if host == "v6only.example" {
+			return []net.IP{net.ParseIP("2001:db8::1")}, nil
+		}
+		return nil, assert.AnError
+	})
+	nx.SetIPSelector(RandomIPSelector())
+
+	_, err := nx.DialContext(context.Background(), "tcp", "unresolvable.example:443")
+	var dialErr *DialError
+	require.True(t, errors.As(err, &dialErr), "resolution failures should be DialErrors")
+	assert.Empty(t, dialErr.Attempts)
+	assert.Equal(t, "unresolvable.example:443", dialErr.Addr)
+	assert.Contains(t, err.Error(), "unable to dial unresolvable.example:443 (tcp)")
+	assert.Contains(t, err.Error(), assert.AnError.Error())
+
+	nx.SetIPSelector(nil)
+	nx.EnableHappyEyeballs(DefaultHappyEyeballsDelay)
+	_, err = nx.DialContext(context.Background(), "tcp", "unresolvable.example:443")
+	require.True(t, errors.As(err, &dialErr), "happy eyeballs resolution failures should be DialErrors")
+	assert.Empty(t, dialErr.Attempts)
+	assert.NotNil(t, dialErr.Err)
+
+	_, err = nx.DialContext(context.Background(), "tcp4", "v6only.example:443")
+	require.True(t, errors.As(err, &dialErr), "no candidates should be a DialError")
+	assert.Empty(t, dialErr.Attempts)
+	assert.Contains(t, err.Error(), "no addresses to try")
+}
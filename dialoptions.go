@@ -0,0 +1,101 @@
+package netx
+
+import (
+	"context"
+	"net"
+	"syscall"
+	"time"
+)
+
+// DialOptions configures the sockets used for an individual dial. The default
+// dial function honors all of them. Custom dial functions installed with
+// OverrideDial can honor them by calling DialOptionsFromContext.
+type DialOptions struct {
+	// LocalAddr is the local address to dial from, like net.Dialer.LocalAddr.
+	LocalAddr net.Addr
+	// Interface is the name of a network interface to bind to using
+	// SO_BINDTODEVICE. This is only supported on Linux.
+	Interface string
+	// KeepAlive is the interval between TCP keepalive probes, like
+	// net.Dialer.KeepAlive. If zero, a default is used. If negative, keepalives
+	// are disabled.
+	KeepAlive time.Duration
+	// Mark is the SO_MARK to set on the socket, if non-zero. This is only
+	// supported on Linux.
+	Mark int
+	// FastOpen enables TCP Fast Open for TCP networks. The conn that's returned
+	// doesn't connect until the first Write, so that the written data can be
+	// sent along with the SYN. Connection errors are reported by that Write, and
+	// Reads block until it. Where Fast Open isn't supported, this connects
+	// normally on the first Write. See TCPFeaturesOf.
+	//
+	// Since dialing itself always succeeds, features that react to dial
+	// failures are bypassed: there's no fallback from a NAT64 synthesized
+	// address to the original one, Happy Eyeballs uses the first candidate, the
+	// retry policy doesn't retry and no DialError is returned. The circuit
+	// breaker and any DialOutcomeReporter do learn the outcome of the connect
+	// once the first Write has made it.
+	FastOpen bool
+	// MultipathTCP enables Multipath TCP for TCP networks. Where the kernel or
+	// the server doesn't support it, this falls back to regular TCP. See
+	// TCPFeaturesOf.
+	MultipathTCP bool
+	// Control, if not nil, is called after the socket options above have been
+	// set and before connecting, like net.Dialer.Control.
+	Control func(network, address string, c syscall.RawConn) error
+}
+
+type dialOptionsKey struct{}
+
+// DialContextWithOptions is like DialContext but uses the given DialOptions.
+func DialContextWithOptions(ctx context.Context, network string, addr string, opts *DialOptions) (net.Conn, error) {
+	return defaultNetx.DialContextWithOptions(ctx, network, addr, opts)
+}
+
+// DialContextWithOptions is like DialContext but uses the given DialOptions.
+// The options apply to every address that's tried.
+func (nx *Netx) DialContextWithOptions(ctx context.Context, network string, addr string, opts *DialOptions) (net.Conn, error) {
+	if opts != nil {
+		ctx = context.WithValue(ctx, dialOptionsKey{}, opts)
+	}
+	return nx.DialContext(ctx, network, addr)
+}
+
+// DialOptionsFromContext returns the DialOptions passed to
+// DialContextWithOptions, or nil if there aren't any.
+func DialOptionsFromContext(ctx context.Context) *DialOptions {
+	opts, _ := ctx.Value(dialOptionsKey{}).(*DialOptions)
+	return opts
+}
+
+// dialWithOptions is the default dial function. It dials with a net.Dialer
+// configured from the DialOptions in ctx.
+func dialWithOptions(ctx context.Context, network string, addr string) (net.Conn, error) {
+	var d net.Dialer
+	opts := DialOptionsFromContext(ctx)
+	if opts == nil {
+		return d.DialContext(ctx, network, addr)
+	}
+	d.LocalAddr = opts.LocalAddr
+	d.KeepAlive = opts.KeepAlive
+	if opts.Interface != "" || opts.Mark != 0 || opts.Control != nil {
+		d.Control = opts.control
+	}
+	if opts.MultipathTCP {
+		d.SetMultipathTCP(true)
+	}
+	if opts.FastOpen && isTCP(network) {
+		return newFastOpenConn(ctx, d, network, addr), nil
+	}
+	return d.DialContext(ctx, network, addr)
+}
+
+func (opts *DialOptions) control(network, address string, c syscall.RawConn) error {
+	if err := opts.setSockopts(c); err != nil {
+		return err
+	}
+	if opts.Control != nil {
+		return opts.Control(network, address, c)
+	}
+	return nil
+}
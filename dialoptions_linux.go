@@ -0,0 +1,49 @@
+//go:build linux
+
+package netx
+
+import (
+	"syscall"
+
+	"github.com/getlantern/errors"
+)
+
+// setSockopts applies the Linux-specific socket options.
+func (opts *DialOptions) setSockopts(c syscall.RawConn) error {
+	var sockErr error
+	err := c.Control(func(fd uintptr) {
+		if opts.Interface != "" {
+			if sockErr = syscall.BindToDevice(int(fd), opts.Interface); sockErr != nil {
+				sockErr = errors.New("Unable to bind to interface %v: %v", opts.Interface, sockErr)
+				return
+			}
+		}
+		if opts.Mark != 0 {
+			if sockErr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_MARK, opts.Mark); sockErr != nil {
+				sockErr = errors.New("Unable to set mark %d: %v", opts.Mark, sockErr)
+				return
+			}
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return sockErr
+}
+
+// tcpFastOpenConnect is TCP_FASTOPEN_CONNECT from linux/tcp.h, which the
+// syscall package doesn't define.
+const tcpFastOpenConnect = 30
+
+// setFastOpen enables client-side TCP Fast Open, which makes connect return
+// right away and the first write send the SYN along with the data.
+func setFastOpen(c syscall.RawConn) error {
+	var sockErr error
+	err := c.Control(func(fd uintptr) {
+		sockErr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_TCP, tcpFastOpenConnect, 1)
+	})
+	if err != nil {
+		return err
+	}
+	return sockErr
+}
@@ -0,0 +1,37 @@
+//go:build linux
+
+package netx
+
+import (
+	"context"
+	"errors"
+	"net"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDialOptionsLinuxSockopts(t *testing.T) {
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+
+	var mark int
+	conn, err := DialContextWithOptions(context.Background(), "tcp4", l.Addr().String(), &DialOptions{
+		Interface: "lo",
+		Mark:      42,
+		Control: func(network, address string, c syscall.RawConn) error {
+			return c.Control(func(fd uintptr) {
+				mark, _ = syscall.GetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_MARK)
+			})
+		},
+	})
+	if errors.Is(err, syscall.EPERM) {
+		t.Skip("setting SO_MARK and SO_BINDTODEVICE requires CAP_NET_ADMIN/CAP_NET_RAW")
+	}
+	require.NoError(t, err)
+	defer conn.Close()
+	assert.Equal(t, 42, mark)
+}
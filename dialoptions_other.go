@@ -0,0 +1,26 @@
+//go:build !linux
+
+package netx
+
+import (
+	"syscall"
+
+	"github.com/getlantern/errors"
+)
+
+// setSockopts fails if any Linux-specific socket options were requested.
+func (opts *DialOptions) setSockopts(c syscall.RawConn) error {
+	if opts.Interface != "" {
+		return errors.New("Binding to an interface is not supported on this platform")
+	}
+	if opts.Mark != 0 {
+		return errors.New("Setting a socket mark is not supported on this platform")
+	}
+	return nil
+}
+
+// setFastOpen always fails, since client-side TCP Fast Open is only supported
+// on Linux.
+func setFastOpen(c syscall.RawConn) error {
+	return errors.New("TCP Fast Open is not supported on this platform")
+}
@@ -0,0 +1,73 @@
+package netx
+
+import (
+	"context"
+	"net"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDialContextWithOptions(t *testing.T) {
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err == nil {
+			accepted <- conn
+		}
+	}()
+
+	local, err := net.ResolveTCPAddr("tcp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	var controlled string
+	nx := New()
+	conn, err := nx.DialContextWithOptions(context.Background(), "tcp4", l.Addr().String(), &DialOptions{
+		LocalAddr: local,
+		KeepAlive: -1,
+		Control: func(network, address string, c syscall.RawConn) error {
+			controlled = address
+			return nil
+		},
+	})
+	require.NoError(t, err)
+	defer conn.Close()
+	assert.Equal(t, l.Addr().String(), controlled)
+
+	serverConn := <-accepted
+	defer serverConn.Close()
+	assert.Equal(t, conn.LocalAddr().String(), serverConn.RemoteAddr().String())
+	assert.Equal(t, "127.0.0.1", conn.LocalAddr().(*net.TCPAddr).IP.String())
+}
+
+func TestDialContextWithOptionsControlError(t *testing.T) {
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+
+	_, err = DialContextWithOptions(context.Background(), "tcp4", l.Addr().String(), &DialOptions{
+		Control: func(network, address string, c syscall.RawConn) error {
+			return assert.AnError
+		},
+	})
+	assert.ErrorIs(t, err, assert.AnError)
+}
+
+func TestDialOptionsFromContext(t *testing.T) {
+	opts := &DialOptions{Mark: 5}
+	var seen *DialOptions
+	nx := New()
+	nx.OverrideDial(func(ctx context.Context, network, addr string) (net.Conn, error) {
+		seen = DialOptionsFromContext(ctx)
+		return nil, assert.AnError
+	})
+	_, _ = nx.DialContextWithOptions(context.Background(), "tcp", "127.0.0.1:1", opts)
+	assert.Equal(t, opts, seen)
+
+	_, _ = nx.DialContext(context.Background(), "tcp", "127.0.0.1:1")
+	assert.Nil(t, seen)
+}
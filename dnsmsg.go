@@ -0,0 +1,202 @@
+package netx
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/getlantern/errors"
+)
+
+// This file contains just enough of the DNS wire format (RFC 1035) to query
+// for A and AAAA records over DoH and DoT.
+
+const (
+	dnsTypeA     = 1
+	dnsTypeAAAA  = 28
+	dnsClassINET = 1
+
+	dnsHeaderLen     = 12
+	dnsFlagResponse  = 1 << 15
+	dnsFlagRecursion = 1 << 8
+	dnsRcodeMask     = 0xF
+	dnsRcodeNXDomain = 3
+	dnsMaxMsgLen     = 65535
+)
+
+// buildDNSQuery builds a recursive query for records of type qtype for host.
+func buildDNSQuery(id uint16, host string, qtype uint16) ([]byte, error) {
+	msg := make([]byte, dnsHeaderLen, dnsHeaderLen+len(host)+6)
+	binary.BigEndian.PutUint16(msg[0:], id)
+	binary.BigEndian.PutUint16(msg[2:], dnsFlagRecursion)
+	binary.BigEndian.PutUint16(msg[4:], 1) // QDCOUNT
+
+	name := strings.TrimSuffix(host, ".")
+	if name == "" || len(name) > 253 {
+		return nil, errors.New("Invalid host name %v", host)
+	}
+	for _, label := range strings.Split(name, ".") {
+		if len(label) == 0 || len(label) > 63 {
+			return nil, errors.New("Invalid host name %v", host)
+		}
+		msg = append(msg, byte(len(label)))
+		msg = append(msg, label...)
+	}
+	msg = append(msg, 0)
+	msg = binary.BigEndian.AppendUint16(msg, qtype)
+	msg = binary.BigEndian.AppendUint16(msg, dnsClassINET)
+	return msg, nil
+}
+
+// parseDNSResponse parses a response to a query built with buildDNSQuery,
+// returning the addresses of type qtype and the smallest TTL among them.
+func parseDNSResponse(msg []byte, id uint16, qtype uint16, host string) ([]net.IP, time.Duration, error) {
+	if len(msg) < dnsHeaderLen {
+		return nil, 0, errors.New("DNS response too short")
+	}
+	if binary.BigEndian.Uint16(msg[0:]) != id {
+		return nil, 0, errors.New("DNS response has unexpected id")
+	}
+	flags := binary.BigEndian.Uint16(msg[2:])
+	if flags&dnsFlagResponse == 0 {
+		return nil, 0, errors.New("DNS message is not a response")
+	}
+	switch rcode := flags & dnsRcodeMask; rcode {
+	case 0:
+		// okay
+	case dnsRcodeNXDomain:
+		return nil, 0, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	default:
+		return nil, 0, &net.DNSError{Err: "server misbehaving, rcode " + strconv.Itoa(int(rcode)), Name: host}
+	}
+	qdcount := int(binary.BigEndian.Uint16(msg[4:]))
+	ancount := int(binary.BigEndian.Uint16(msg[6:]))
+
+	off := dnsHeaderLen
+	var err error
+	for i := 0; i < qdcount; i++ {
+		if off, err = skipDNSName(msg, off); err != nil {
+			return nil, 0, err
+		}
+		off += 4 // QTYPE and QCLASS
+	}
+
+	var ips []net.IP
+	var minTTL time.Duration
+	for i := 0; i < ancount; i++ {
+		if off, err = skipDNSName(msg, off); err != nil {
+			return nil, 0, err
+		}
+		if off+10 > len(msg) {
+			return nil, 0, errors.New("DNS response truncated")
+		}
+		rtype := binary.BigEndian.Uint16(msg[off:])
+		class := binary.BigEndian.Uint16(msg[off+2:])
+		ttl := time.Duration(binary.BigEndian.Uint32(msg[off+4:])) * time.Second
+		rdlen := int(binary.BigEndian.Uint16(msg[off+8:]))
+		off += 10
+		if off+rdlen > len(msg) {
+			return nil, 0, errors.New("DNS response truncated")
+		}
+		rdata := msg[off : off+rdlen]
+		off += rdlen
+		if class != dnsClassINET || rtype != qtype {
+			// most likely a CNAME
+			continue
+		}
+		if (rtype == dnsTypeA && rdlen != net.IPv4len) || (rtype == dnsTypeAAAA && rdlen != net.IPv6len) {
+			return nil, 0, errors.New("DNS response has invalid address length %d", rdlen)
+		}
+		ip := make(net.IP, rdlen)
+		copy(ip, rdata)
+		ips = append(ips, ip)
+		if len(ips) == 1 || ttl < minTTL {
+			minTTL = ttl
+		}
+	}
+	return ips, minTTL, nil
+}
+
+// skipDNSName returns the offset just past the (possibly compressed) name
+// starting at off.
+func skipDNSName(msg []byte, off int) (int, error) {
+	for {
+		if off >= len(msg) {
+			return 0, errors.New("DNS response truncated")
+		}
+		l := int(msg[off])
+		switch {
+		case l == 0:
+			return off + 1, nil
+		case l&0xC0 == 0xC0:
+			// compression pointer, which always ends the name
+			return off + 2, nil
+		default:
+			off += 1 + l
+		}
+	}
+}
+
+// dnsQueryFunc queries for records of the given type.
+type dnsQueryFunc func(ctx context.Context, host string, qtype uint16) ([]net.IP, time.Duration, error)
+
+// lookupIPTTLUsing looks up the addresses for host on the given network using
+// query, issuing the A and AAAA queries concurrently when both are needed.
+func lookupIPTTLUsing(ctx context.Context, network, host string, query dnsQueryFunc) ([]net.IP, time.Duration, error) {
+	var qtypes []uint16
+	switch network {
+	case "ip4":
+		qtypes = []uint16{dnsTypeA}
+	case "ip6":
+		qtypes = []uint16{dnsTypeAAAA}
+	default:
+		qtypes = []uint16{dnsTypeA, dnsTypeAAAA}
+	}
+
+	type result struct {
+		ips []net.IP
+		ttl time.Duration
+		err error
+	}
+	results := make([]result, len(qtypes))
+	var wg sync.WaitGroup
+	for i, qtype := range qtypes {
+		wg.Add(1)
+		go func(i int, qtype uint16) {
+			defer wg.Done()
+			ips, ttl, err := query(ctx, host, qtype)
+			results[i] = result{ips, ttl, err}
+		}(i, qtype)
+	}
+	wg.Wait()
+
+	var ips []net.IP
+	var minTTL time.Duration
+	var firstErr error
+	for _, r := range results {
+		if r.err != nil {
+			if firstErr == nil {
+				firstErr = r.err
+			}
+			continue
+		}
+		if len(r.ips) == 0 {
+			continue
+		}
+		if len(ips) == 0 || r.ttl < minTTL {
+			minTTL = r.ttl
+		}
+		ips = append(ips, r.ips...)
+	}
+	if len(ips) == 0 {
+		if firstErr != nil {
+			return nil, 0, firstErr
+		}
+		return nil, 0, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	return ips, minTTL, nil
+}
@@ -0,0 +1,87 @@
+package netx
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// buildTestDNSResponse answers the given query with a CNAME followed by the
+// given addresses, using name compression for the answers.
+func buildTestDNSResponse(t *testing.T, query []byte, ips []net.IP, ttl uint32) []byte {
+	qend, err := skipDNSName(query, dnsHeaderLen)
+	require.NoError(t, err)
+	qtype := binary.BigEndian.Uint16(query[qend:])
+	qend += 4
+
+	resp := append([]byte{}, query[:qend]...)
+	binary.BigEndian.PutUint16(resp[2:], dnsFlagResponse|dnsFlagRecursion)
+	answers := 0
+
+	// CNAME pointing at the name in the question, which should be skipped
+	resp = append(resp, 0xC0, dnsHeaderLen)
+	resp = binary.BigEndian.AppendUint16(resp, 5)
+	resp = binary.BigEndian.AppendUint16(resp, dnsClassINET)
+	resp = binary.BigEndian.AppendUint32(resp, ttl)
+	resp = binary.BigEndian.AppendUint16(resp, 2)
+	resp = append(resp, 0xC0, dnsHeaderLen)
+	answers++
+
+	for _, ip := range ips {
+		rdata := ip.To4()
+		rtype := uint16(dnsTypeA)
+		if rdata == nil {
+			rdata = ip.To16()
+			rtype = dnsTypeAAAA
+		}
+		if rtype != qtype {
+			continue
+		}
+		resp = append(resp, 0xC0, dnsHeaderLen)
+		resp = binary.BigEndian.AppendUint16(resp, rtype)
+		resp = binary.BigEndian.AppendUint16(resp, dnsClassINET)
+		resp = binary.BigEndian.AppendUint32(resp, ttl)
+		resp = binary.BigEndian.AppendUint16(resp, uint16(len(rdata)))
+		resp = append(resp, rdata...)
+		ttl++
+		answers++
+	}
+	binary.BigEndian.PutUint16(resp[6:], uint16(answers))
+	return resp
+}
+
+func TestDNSQueryRoundTrip(t *testing.T) {
+	query, err := buildDNSQuery(1234, "www.example.com.", dnsTypeAAAA)
+	require.NoError(t, err)
+	ips := []net.IP{net.ParseIP("192.0.2.1"), net.ParseIP("2001:db8::1"), net.ParseIP("2001:db8::2")}
+	resp := buildTestDNSResponse(t, query, ips, 300)
+
+	result, ttl, err := parseDNSResponse(resp, 1234, dnsTypeAAAA, "www.example.com")
+	require.NoError(t, err)
+	assert.Equal(t, []net.IP{net.ParseIP("2001:db8::1"), net.ParseIP("2001:db8::2")}, result)
+	assert.Equal(t, 300*time.Second, ttl)
+
+	_, _, err = parseDNSResponse(resp, 4321, dnsTypeAAAA, "www.example.com")
+	assert.Error(t, err, "mismatched id should be rejected")
+	_, _, err = parseDNSResponse(resp[:len(resp)-3], 1234, dnsTypeAAAA, "www.example.com")
+	assert.Error(t, err, "truncated response should be rejected")
+
+	_, err = buildDNSQuery(1, "bad..name", dnsTypeA)
+	assert.Error(t, err)
+}
+
+func TestDNSNXDomain(t *testing.T) {
+	query, err := buildDNSQuery(1, "nonexistent.example.com", dnsTypeA)
+	require.NoError(t, err)
+	resp := buildTestDNSResponse(t, query, nil, 300)
+	binary.BigEndian.PutUint16(resp[2:], dnsFlagResponse|dnsRcodeNXDomain)
+
+	_, _, err = parseDNSResponse(resp, 1, dnsTypeA, "nonexistent.example.com")
+	dnsErr, ok := err.(*net.DNSError)
+	require.True(t, ok)
+	assert.True(t, dnsErr.IsNotFound)
+}
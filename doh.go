@@ -0,0 +1,99 @@
+package netx
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/getlantern/errors"
+)
+
+const (
+	dnsMessageContentType = "application/dns-message"
+)
+
+// DoHResolver is a Resolver that looks up addresses using DNS-over-HTTPS (RFC
+// 8484). Connections to the server are made using Dial, which defaults to the
+// global DialContext. To avoid resolving the server's own name through itself,
+// URL should generally use an IP address as its host.
+type DoHResolver struct {
+	// URL is the URL of the DoH endpoint, for example
+	// https://1.1.1.1/dns-query.
+	URL string
+	// TLSConfig optionally configures the TLS connection to the server.
+	TLSConfig *tls.Config
+	// Dial is used to connect to the server. Defaults to the global DialContext.
+	Dial func(ctx context.Context, network, addr string) (net.Conn, error)
+
+	clientOnce sync.Once
+	client     *http.Client
+}
+
+// NewDoHResolver constructs a new DoHResolver for the given URL that dials
+// using this Netx.
+func (nx *Netx) NewDoHResolver(url string) *DoHResolver {
+	return &DoHResolver{URL: url, Dial: nx.DialContext}
+}
+
+// LookupIP implements the method from the Resolver interface.
+func (r *DoHResolver) LookupIP(ctx context.Context, network, host string) ([]net.IP, error) {
+	ips, _, err := r.LookupIPTTL(ctx, network, host)
+	return ips, err
+}
+
+// LookupIPTTL implements the method from the TTLResolver interface.
+func (r *DoHResolver) LookupIPTTL(ctx context.Context, network, host string) ([]net.IP, time.Duration, error) {
+	return lookupIPTTLUsing(ctx, network, host, r.query)
+}
+
+func (r *DoHResolver) query(ctx context.Context, host string, qtype uint16) ([]net.IP, time.Duration, error) {
+	// RFC 8484 recommends an id of 0 to maximize HTTP cache friendliness
+	query, err := buildDNSQuery(0, host, qtype)
+	if err != nil {
+		return nil, 0, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.URL, bytes.NewReader(query))
+	if err != nil {
+		return nil, 0, errors.New("Unable to build DoH request: %v", err)
+	}
+	req.Header.Set("Content-Type", dnsMessageContentType)
+	req.Header.Set("Accept", dnsMessageContentType)
+	resp, err := r.getClient().Do(req)
+	if err != nil {
+		return nil, 0, errors.New("Unable to query DoH server at %v: %v", r.URL, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, 0, errors.New("Unexpected status from DoH server at %v: %v", r.URL, resp.Status)
+	}
+	msg, err := io.ReadAll(io.LimitReader(resp.Body, dnsMaxMsgLen))
+	if err != nil {
+		return nil, 0, errors.New("Unable to read DoH response from %v: %v", r.URL, err)
+	}
+	return parseDNSResponse(msg, 0, qtype, host)
+}
+
+func (r *DoHResolver) getClient() *http.Client {
+	r.clientOnce.Do(func() {
+		dial := r.Dial
+		if dial == nil {
+			dial = DialContext
+		}
+		r.client = &http.Client{
+			Transport: &http.Transport{
+				DialContext:         dial,
+				TLSClientConfig:     r.TLSConfig,
+				ForceAttemptHTTP2:   true,
+				MaxIdleConns:        2,
+				IdleConnTimeout:     90 * time.Second,
+				TLSHandshakeTimeout: 10 * time.Second,
+			},
+		}
+	})
+	return r.client
+}
@@ -0,0 +1,52 @@
+package netx
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDoHResolver(t *testing.T) {
+	ips := []net.IP{net.ParseIP("192.0.2.1").To4(), net.ParseIP("2001:db8::1")}
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		if !assert.Equal(t, dnsMessageContentType, req.Header.Get("Content-Type")) {
+			resp.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		query, err := io.ReadAll(req.Body)
+		if !assert.NoError(t, err) {
+			return
+		}
+		resp.Header().Set("Content-Type", dnsMessageContentType)
+		resp.Write(buildTestDNSResponse(t, query, ips, 60))
+	}))
+	defer srv.Close()
+
+	var dials int32
+	nx := New()
+	nx.OverrideDial(func(ctx context.Context, network, addr string) (net.Conn, error) {
+		atomic.AddInt32(&dials, 1)
+		var d net.Dialer
+		return d.DialContext(ctx, network, addr)
+	})
+	r := nx.NewDoHResolver(srv.URL + "/dns-query")
+	r.TLSConfig = srv.Client().Transport.(*http.Transport).TLSClientConfig
+
+	result, ttl, err := r.LookupIPTTL(context.Background(), "ip", "example.com")
+	require.NoError(t, err)
+	assert.Equal(t, ips, result)
+	assert.Equal(t, 60*time.Second, ttl)
+	assert.True(t, atomic.LoadInt32(&dials) > 0, "should have dialed using the Netx's dial function")
+
+	result, err = r.LookupIP(context.Background(), "ip6", "example.com")
+	require.NoError(t, err)
+	assert.Equal(t, []net.IP{net.ParseIP("2001:db8::1")}, result)
+}
@@ -0,0 +1,116 @@
+package netx
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"io"
+	"math/rand"
+	"net"
+	"time"
+
+	"github.com/getlantern/errors"
+)
+
+// DoTResolver is a Resolver that looks up addresses using DNS-over-TLS (RFC
+// 7858). Each query is made over a new connection, which is established using
+// Dial and defaults to the global DialContext.
+type DoTResolver struct {
+	// Addr is the host:port of the DoT server, for example 1.1.1.1:853.
+	Addr string
+	// ServerName is the name used to verify the server's certificate. Ignored if
+	// TLSConfig specifies a ServerName. If neither does, the host part of Addr
+	// is used, which may be an IP address.
+	ServerName string
+	// TLSConfig optionally configures the TLS connection to the server.
+	TLSConfig *tls.Config
+	// Dial is used to connect to the server. Defaults to the global DialContext.
+	Dial func(ctx context.Context, network, addr string) (net.Conn, error)
+}
+
+// NewDoTResolver constructs a new DoTResolver for the given server address
+// and server name that dials using this Netx.
+func (nx *Netx) NewDoTResolver(addr string, serverName string) *DoTResolver {
+	return &DoTResolver{Addr: addr, ServerName: serverName, Dial: nx.DialContext}
+}
+
+// LookupIP implements the method from the Resolver interface.
+func (r *DoTResolver) LookupIP(ctx context.Context, network, host string) ([]net.IP, error) {
+	ips, _, err := r.LookupIPTTL(ctx, network, host)
+	return ips, err
+}
+
+// LookupIPTTL implements the method from the TTLResolver interface.
+func (r *DoTResolver) LookupIPTTL(ctx context.Context, network, host string) ([]net.IP, time.Duration, error) {
+	return lookupIPTTLUsing(ctx, network, host, r.query)
+}
+
+func (r *DoTResolver) query(ctx context.Context, host string, qtype uint16) ([]net.IP, time.Duration, error) {
+	id := uint16(rand.Intn(65536))
+	query, err := buildDNSQuery(id, host, qtype)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	conn, err := r.dialTLS(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	stop := context.AfterFunc(ctx, func() {
+		// unblock any pending reads or writes
+		conn.SetDeadline(time.Now())
+	})
+	defer stop()
+
+	// messages over TCP are prefixed with a 2 byte length (RFC 1035 4.2.2)
+	framed := make([]byte, 2, 2+len(query))
+	binary.BigEndian.PutUint16(framed, uint16(len(query)))
+	framed = append(framed, query...)
+	if _, err := conn.Write(framed); err != nil {
+		return nil, 0, errors.New("Unable to write DoT query to %v: %v", r.Addr, err)
+	}
+	var length [2]byte
+	if _, err := io.ReadFull(conn, length[:]); err != nil {
+		return nil, 0, errors.New("Unable to read DoT response from %v: %v", r.Addr, err)
+	}
+	msg := make([]byte, binary.BigEndian.Uint16(length[:]))
+	if _, err := io.ReadFull(conn, msg); err != nil {
+		return nil, 0, errors.New("Unable to read DoT response from %v: %v", r.Addr, err)
+	}
+	return parseDNSResponse(msg, id, qtype, host)
+}
+
+func (r *DoTResolver) dialTLS(ctx context.Context) (*tls.Conn, error) {
+	dial := r.Dial
+	if dial == nil {
+		dial = DialContext
+	}
+	rawConn, err := dial(ctx, "tcp", r.Addr)
+	if err != nil {
+		return nil, errors.New("Unable to dial DoT server at %v: %v", r.Addr, err)
+	}
+	var cfg *tls.Config
+	if r.TLSConfig != nil {
+		cfg = r.TLSConfig.Clone()
+	} else {
+		cfg = &tls.Config{}
+	}
+	if cfg.ServerName == "" {
+		cfg.ServerName = r.ServerName
+	}
+	if cfg.ServerName == "" {
+		if host, _, err := net.SplitHostPort(r.Addr); err == nil {
+			cfg.ServerName = host
+		}
+	}
+	conn := tls.Client(rawConn, cfg)
+	if err := conn.HandshakeContext(ctx); err != nil {
+		rawConn.Close()
+		return nil, errors.New("Unable to complete TLS handshake with DoT server at %v: %v", r.Addr, err)
+	}
+	return conn, nil
+}
@@ -0,0 +1,68 @@
+package netx
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDoTResolver(t *testing.T) {
+	// borrow httptest's certificate and the matching client config
+	certSrv := httptest.NewTLSServer(http.NotFoundHandler())
+	defer certSrv.Close()
+	l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: certSrv.TLS.Certificates})
+	require.NoError(t, err)
+	defer l.Close()
+
+	ips := []net.IP{net.ParseIP("192.0.2.1").To4(), net.ParseIP("2001:db8::1")}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				var length [2]byte
+				if _, err := io.ReadFull(conn, length[:]); err != nil {
+					return
+				}
+				query := make([]byte, binary.BigEndian.Uint16(length[:]))
+				if _, err := io.ReadFull(conn, query); err != nil {
+					return
+				}
+				resp := buildTestDNSResponse(t, query, ips, 30)
+				framed := binary.BigEndian.AppendUint16(nil, uint16(len(resp)))
+				conn.Write(append(framed, resp...))
+			}()
+		}
+	}()
+
+	r := New().NewDoTResolver(l.Addr().String(), "example.com")
+	r.TLSConfig = certSrv.Client().Transport.(*http.Transport).TLSClientConfig
+
+	result, ttl, err := r.LookupIPTTL(context.Background(), "ip", "example.com")
+	require.NoError(t, err)
+	assert.Equal(t, ips, result)
+	assert.Equal(t, 30*time.Second, ttl)
+
+	result, err = r.LookupIP(context.Background(), "ip4", "example.com")
+	require.NoError(t, err)
+	assert.Equal(t, []net.IP{net.ParseIP("192.0.2.1").To4()}, result)
+
+	// without a server name, the certificate is verified against the host in Addr
+	r = New().NewDoTResolver(l.Addr().String(), "")
+	r.TLSConfig = certSrv.Client().Transport.(*http.Transport).TLSClientConfig
+	result, err = r.LookupIP(context.Background(), "ip", "example.com")
+	require.NoError(t, err)
+	assert.Equal(t, ips, result)
+}
@@ -0,0 +1,89 @@
+package netx
+
+import (
+	"fmt"
+	"net"
+	"sync/atomic"
+)
+
+// FamilyPreference determines which IP address family is preferred when
+// resolving hosts on networks that allow both, like "tcp" and "udp".
+type FamilyPreference int32
+
+const (
+	// PreferIPv4 prefers IPv4 addresses, which is the default and the same
+	// choice that Go's net package makes. Note that localhost can be a special
+	// case: for most hosts, the IPv6 address will still reach the server. This
+	// is often not the case for localhost; a local server is likely to only
+	// listen over IPv4, but DNS will resolve both the IPv4 and IPv6 addresses.
+	// In this case, it can be especially important to prefer IPv4.
+	PreferIPv4 FamilyPreference = iota
+	// PreferIPv6 prefers IPv6 addresses.
+	PreferIPv6
+	// PreferRFC6724 orders addresses according to the destination address
+	// selection rules of RFC 6724 and prefers the family of the first one.
+	PreferRFC6724
+	// PreferAsReturned keeps addresses in the order returned by the Resolver and
+	// doesn't prefer either family.
+	PreferAsReturned
+)
+
+func (p FamilyPreference) String() string {
+	switch p {
+	case PreferIPv4:
+		return "prefer IPv4"
+	case PreferIPv6:
+		return "prefer IPv6"
+	case PreferRFC6724:
+		return "RFC 6724"
+	case PreferAsReturned:
+		return "as returned"
+	default:
+		return fmt.Sprintf("FamilyPreference(%d)", int32(p))
+	}
+}
+
+// SetFamilyPreference sets the global FamilyPreference. See
+// Netx.SetFamilyPreference.
+func SetFamilyPreference(pref FamilyPreference) {
+	defaultNetx.SetFamilyPreference(pref)
+}
+
+// SetFamilyPreference sets which address family Resolve and ResolveUDPAddr
+// pick from on networks that allow both, and how ResolveAllTCP and
+// ResolveAllUDP order their results. The default is PreferIPv4.
+func (nx *Netx) SetFamilyPreference(pref FamilyPreference) {
+	atomic.StoreInt32(&nx.familyPreference, int32(pref))
+}
+
+func (nx *Netx) getFamilyPreference() FamilyPreference {
+	return FamilyPreference(atomic.LoadInt32(&nx.familyPreference))
+}
+
+// order returns ips in order of preference.
+func (p FamilyPreference) order(ips []net.IP) []net.IP {
+	switch p {
+	case PreferIPv4:
+		return append(ipv4Only(ips), ipv6Only(ips)...)
+	case PreferIPv6:
+		return append(ipv6Only(ips), ipv4Only(ips)...)
+	case PreferRFC6724:
+		sorted := append([]net.IP(nil), ips...)
+		sortByRFC6724(sorted)
+		return sorted
+	default:
+		return ips
+	}
+}
+
+// preferred returns the ips, which are already in order of preference, that a
+// single address should be picked from.
+func (p FamilyPreference) preferred(ordered []net.IP) []net.IP {
+	if p == PreferAsReturned || len(ordered) == 0 {
+		return ordered
+	}
+	if ordered[0].To4() != nil {
+		return ipv4Only(ordered)
+	}
+	return ipv6Only(ordered)
+}
@@ -0,0 +1,73 @@
+package netx
+
+import (
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolveAll(t *testing.T) {
+	nx := New()
+	nx.OverrideResolveIPs(func(host string) ([]net.IP, error) {
+		return parseIPs("2001:db8::1", "1.1.1.1", "2001:db8::2", "2.2.2.2"), nil
+	})
+
+	tcpAddrs, err := nx.ResolveAllTCP("tcp", "example.com:80")
+	require.NoError(t, err)
+	assert.Equal(t, "[1.1.1.1:80 2.2.2.2:80 [2001:db8::1]:80 [2001:db8::2]:80]", fmt.Sprint(tcpAddrs))
+
+	tcpAddrs, err = nx.ResolveAllTCP("tcp6", "example.com:80")
+	require.NoError(t, err)
+	assert.Equal(t, "[[2001:db8::1]:80 [2001:db8::2]:80]", fmt.Sprint(tcpAddrs))
+
+	nx.SetFamilyPreference(PreferIPv6)
+	udpAddrs, err := nx.ResolveAllUDP("", "example.com:53")
+	require.NoError(t, err)
+	assert.Equal(t, "[[2001:db8::1]:53 [2001:db8::2]:53 1.1.1.1:53 2.2.2.2:53]", fmt.Sprint(udpAddrs))
+
+	udpAddrs, err = nx.ResolveAllUDP("udp4", "example.com:53")
+	require.NoError(t, err)
+	assert.Equal(t, "[1.1.1.1:53 2.2.2.2:53]", fmt.Sprint(udpAddrs))
+
+	nx.SetFamilyPreference(PreferAsReturned)
+	tcpAddrs, err = nx.ResolveAllTCP("tcp", "example.com:80")
+	require.NoError(t, err)
+	assert.Equal(t, "[[2001:db8::1]:80 1.1.1.1:80 [2001:db8::2]:80 2.2.2.2:80]", fmt.Sprint(tcpAddrs))
+
+	_, err = nx.ResolveAllTCP("udp", "example.com:80")
+	assert.Error(t, err)
+}
+
+func TestResolveFamilyPreference(t *testing.T) {
+	nx := New()
+	nx.OverrideResolveIPs(func(host string) ([]net.IP, error) {
+		return parseIPs("1.1.1.1", "2001:db8::1"), nil
+	})
+	for i := 0; i < 10; i++ {
+		addr, err := nx.Resolve("tcp", "example.com:80")
+		require.NoError(t, err)
+		assert.Equal(t, "1.1.1.1:80", addr.String())
+	}
+
+	nx.SetFamilyPreference(PreferIPv6)
+	for i := 0; i < 10; i++ {
+		addr, err := nx.ResolveUDPAddr("udp", "example.com:80")
+		require.NoError(t, err)
+		assert.Equal(t, "[2001:db8::1]:80", addr.String())
+	}
+
+	nx.Reset()
+	assert.Equal(t, PreferIPv4, nx.getFamilyPreference())
+}
+
+func TestFamilyPreferenceOrder(t *testing.T) {
+	ips := parseIPs("2001:db8::1", "1.1.1.1")
+	assert.Equal(t, parseIPs("1.1.1.1", "2001:db8::1"), PreferIPv4.order(ips))
+	assert.Equal(t, parseIPs("2001:db8::1", "1.1.1.1"), PreferIPv6.order(ips))
+	assert.Equal(t, ips, PreferAsReturned.order(ips))
+	assert.Equal(t, parseIPs("1.1.1.1"), PreferIPv4.preferred(PreferIPv4.order(ips)))
+	assert.Equal(t, ips, PreferAsReturned.preferred(ips))
+}
@@ -0,0 +1,395 @@
+package netx
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// TCPFeatures reports which optional TCP features a conn dialed by netx uses.
+type TCPFeatures struct {
+	// FastOpen indicates that the conn was dialed with TCP Fast Open enabled.
+	// Since the connect is deferred until the first Write, this is only known
+	// after that. The kernel still falls back to a regular handshake if it
+	// doesn't have a Fast Open cookie for the server yet.
+	FastOpen bool
+	// MultipathTCP indicates that the conn uses Multipath TCP.
+	MultipathTCP bool
+}
+
+// TCPFeaturesOf reports which optional TCP features conn uses, looking through
+// any wrapped conns.
+func TCPFeaturesOf(conn net.Conn) TCPFeatures {
+	var features TCPFeatures
+	WalkWrapped(conn, func(conn net.Conn) bool {
+		switch t := conn.(type) {
+		case *fastOpenConn:
+			features.FastOpen = t.usedFastOpen()
+		case *net.TCPConn:
+			features.MultipathTCP, _ = t.MultipathTCP()
+			return false
+		}
+		return true
+	})
+	return features
+}
+
+func isTCP(network string) bool {
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+		return true
+	default:
+		return false
+	}
+}
+
+// fastOpenConn is a conn that doesn't connect until the first Write, so that
+// the data from that Write can be sent along with the SYN using TCP Fast Open.
+// Reads block until the first Write has connected, so it's not suitable for
+// protocols in which the server speaks first. If the write deadline passes
+// while connecting, the connect is abandoned and the conn becomes unusable.
+type fastOpenConn struct {
+	ctx       context.Context
+	timeout   time.Duration
+	dialer    net.Dialer
+	network   string
+	addr      string
+	connected chan struct{}
+	connect   sync.Once
+	closed    chan struct{}
+
+	// these are set before connected is closed
+	conn     net.Conn
+	err      error
+	fastOpen bool
+
+	// deadlines set before connecting are remembered and applied once
+	// connected. readDeadlineChanged is closed and replaced whenever the read
+	// deadline changes, to wake up Reads waiting for the connection.
+	readDeadline        time.Time
+	readDeadlineChanged chan struct{}
+	writeDeadline       time.Time
+	// while connecting, cancelConnect aborts the connect and connectTimer
+	// does so once the write deadline passes
+	cancelConnect context.CancelCauseFunc
+	connectTimer  *time.Timer
+	isClosed      bool
+	// onConnected is called with the outcome of the connect once it's known,
+	// see whenConnected
+	onConnected []func(error)
+	reported    bool
+	reportedErr error
+	mx          sync.Mutex
+}
+
+func newFastOpenConn(ctx context.Context, dialer net.Dialer, network string, addr string) *fastOpenConn {
+	c := &fastOpenConn{
+		// the context that was used for dialing may be canceled as soon as dialing
+		// returns, so keep only its values and remaining time
+		ctx:                 context.WithoutCancel(ctx),
+		dialer:              dialer,
+		network:             network,
+		addr:                addr,
+		connected:           make(chan struct{}),
+		closed:              make(chan struct{}),
+		readDeadlineChanged: make(chan struct{}),
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		c.timeout = time.Until(deadline)
+	}
+	return c
+}
+
+// whenConnected calls report with the outcome of the dial that returned conn
+// and err. For conns that don't connect until the first Write, that's once the
+// connect has finished, so that it isn't mistaken for a successful dial. If
+// the conn is closed or its write deadline passes before that, report is
+// called with context.Canceled, since the caller gave up.
+func whenConnected(conn net.Conn, err error, report func(error)) {
+	var deferred *fastOpenConn
+	if err == nil {
+		WalkWrapped(conn, func(conn net.Conn) bool {
+			deferred, _ = conn.(*fastOpenConn)
+			return deferred == nil
+		})
+	}
+	if deferred == nil {
+		report(err)
+		return
+	}
+	deferred.mx.Lock()
+	if !deferred.reported {
+		deferred.onConnected = append(deferred.onConnected, report)
+		deferred.mx.Unlock()
+		return
+	}
+	err = deferred.reportedErr
+	deferred.mx.Unlock()
+	report(err)
+}
+
+// reportConnected calls the functions registered with whenConnected, unless
+// that has already happened.
+func (c *fastOpenConn) reportConnected(err error) {
+	c.mx.Lock()
+	if c.reported {
+		c.mx.Unlock()
+		return
+	}
+	c.reported = true
+	c.reportedErr = err
+	onConnected := c.onConnected
+	c.onConnected = nil
+	c.mx.Unlock()
+	for _, report := range onConnected {
+		report(err)
+	}
+}
+
+func (c *fastOpenConn) Write(b []byte) (int, error) {
+	first := false
+	var n int
+	var err error
+	c.connect.Do(func() {
+		first = true
+		n, err = c.connectAndWrite(b)
+	})
+	if first {
+		return n, err
+	}
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.conn.Write(b)
+}
+
+func (c *fastOpenConn) connectAndWrite(b []byte) (int, error) {
+	// Close and the write deadline cancel the connect with a cause, whereas the
+	// dial timeout doesn't
+	cancelCtx, cancel := context.WithCancelCause(c.ctx)
+	defer cancel(nil)
+	ctx := cancelCtx
+	if c.timeout > 0 {
+		var cancelTimeout context.CancelFunc
+		ctx, cancelTimeout = context.WithTimeout(ctx, c.timeout)
+		defer cancelTimeout()
+	}
+
+	c.mx.Lock()
+	if c.isClosed {
+		c.mx.Unlock()
+		return 0, c.fail(net.ErrClosed, context.Canceled)
+	}
+	c.cancelConnect = cancel
+	c.resetConnectTimerLocked()
+	c.mx.Unlock()
+
+	control := c.dialer.Control
+	c.dialer.Control = func(network, address string, rc syscall.RawConn) error {
+		// if the kernel doesn't support Fast Open, just connect normally
+		c.fastOpen = setFastOpen(rc) == nil
+		if control != nil {
+			return control(network, address, rc)
+		}
+		return nil
+	}
+	conn, err := c.dialer.DialContext(ctx, c.network, c.addr)
+
+	c.mx.Lock()
+	c.cancelConnect = nil
+	c.resetConnectTimerLocked()
+	if err != nil || c.isClosed {
+		closed := c.isClosed
+		c.mx.Unlock()
+		cause := context.Cause(cancelCtx)
+		switch {
+		case closed:
+			if conn != nil {
+				conn.Close()
+			}
+			return 0, c.fail(net.ErrClosed, context.Canceled)
+		case cause != nil:
+			return 0, c.fail(cause, context.Canceled)
+		default:
+			return 0, c.fail(err, err)
+		}
+	}
+	c.conn = conn
+	if !c.readDeadline.IsZero() {
+		_ = conn.SetReadDeadline(c.readDeadline)
+	}
+	if !c.writeDeadline.IsZero() {
+		_ = conn.SetWriteDeadline(c.writeDeadline)
+	}
+	c.mx.Unlock()
+	close(c.connected)
+	c.reportConnected(nil)
+	// with Fast Open, this is what actually sends the SYN
+	return conn.Write(b)
+}
+
+// fail records that connecting failed with err and reports reportErr to
+// whenConnected.
+func (c *fastOpenConn) fail(err error, reportErr error) error {
+	c.fastOpen = false
+	c.err = err
+	close(c.connected)
+	c.reportConnected(reportErr)
+	return err
+}
+
+// resetConnectTimerLocked arranges for the connect to be canceled once the
+// write deadline passes, if connecting. It must be called with c.mx held.
+func (c *fastOpenConn) resetConnectTimerLocked() {
+	if c.connectTimer != nil {
+		c.connectTimer.Stop()
+		c.connectTimer = nil
+	}
+	if c.cancelConnect == nil || c.writeDeadline.IsZero() {
+		return
+	}
+	cancel := c.cancelConnect
+	c.connectTimer = time.AfterFunc(time.Until(c.writeDeadline), func() {
+		cancel(os.ErrDeadlineExceeded)
+	})
+}
+
+func (c *fastOpenConn) Read(b []byte) (int, error) {
+	if err := c.waitConnected(); err != nil {
+		return 0, err
+	}
+	return c.conn.Read(b)
+}
+
+func (c *fastOpenConn) waitConnected() error {
+	for {
+		select {
+		case <-c.connected:
+			return c.err
+		default:
+		}
+		c.mx.Lock()
+		deadline := c.readDeadline
+		changed := c.readDeadlineChanged
+		c.mx.Unlock()
+		var timeout <-chan time.Time
+		if !deadline.IsZero() {
+			wait := time.Until(deadline)
+			if wait <= 0 {
+				return os.ErrDeadlineExceeded
+			}
+			timer := time.NewTimer(wait)
+			defer timer.Stop()
+			timeout = timer.C
+		}
+		select {
+		case <-c.connected:
+			return c.err
+		case <-c.closed:
+			return net.ErrClosed
+		case <-timeout:
+			return os.ErrDeadlineExceeded
+		case <-changed:
+			// check again with the new deadline
+		}
+	}
+}
+
+// Close doesn't wait for a connect that's in progress but cancels it, so that
+// it can't block for as long as the dial timeout.
+func (c *fastOpenConn) Close() error {
+	c.mx.Lock()
+	conn := c.conn
+	cancel := c.cancelConnect
+	connecting := cancel != nil
+	if !c.isClosed {
+		c.isClosed = true
+		close(c.closed)
+	}
+	c.mx.Unlock()
+	if conn != nil {
+		return conn.Close()
+	}
+	if connecting {
+		cancel(net.ErrClosed)
+	} else {
+		// nothing will be dialed unless a Write is already on its way to
+		// connecting, in which case it reports the outcome itself
+		c.reportConnected(context.Canceled)
+	}
+	return nil
+}
+
+func (c *fastOpenConn) LocalAddr() net.Addr {
+	if conn := c.Wrapped(); conn != nil {
+		return conn.LocalAddr()
+	}
+	if c.dialer.LocalAddr != nil {
+		return c.dialer.LocalAddr
+	}
+	return &net.TCPAddr{}
+}
+
+func (c *fastOpenConn) RemoteAddr() net.Addr {
+	if conn := c.Wrapped(); conn != nil {
+		return conn.RemoteAddr()
+	}
+	if addrPort, err := netip.ParseAddrPort(c.addr); err == nil {
+		return net.TCPAddrFromAddrPort(addrPort)
+	}
+	return &net.TCPAddr{}
+}
+
+func (c *fastOpenConn) SetDeadline(t time.Time) error {
+	if err := c.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return c.SetWriteDeadline(t)
+}
+
+func (c *fastOpenConn) SetReadDeadline(t time.Time) error {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	if c.conn != nil {
+		return c.conn.SetReadDeadline(t)
+	}
+	c.readDeadline = t
+	close(c.readDeadlineChanged)
+	c.readDeadlineChanged = make(chan struct{})
+	return nil
+}
+
+func (c *fastOpenConn) SetWriteDeadline(t time.Time) error {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	if c.conn != nil {
+		return c.conn.SetWriteDeadline(t)
+	}
+	c.writeDeadline = t
+	c.resetConnectTimerLocked()
+	return nil
+}
+
+// Wrapped implements the interface WrappedConn. It returns nil until
+// connected.
+func (c *fastOpenConn) Wrapped() net.Conn {
+	select {
+	case <-c.connected:
+		return c.conn
+	default:
+		return nil
+	}
+}
+
+func (c *fastOpenConn) usedFastOpen() bool {
+	select {
+	case <-c.connected:
+		return c.fastOpen
+	default:
+		return false
+	}
+}
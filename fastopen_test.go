@@ -0,0 +1,263 @@
+package netx
+
+import (
+	"context"
+	"io"
+	"net"
+	"os"
+	"runtime"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func startEchoServer(l net.Listener) {
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_, _ = io.Copy(conn, conn)
+			}()
+		}
+	}()
+}
+
+func TestFastOpen(t *testing.T) {
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	startEchoServer(l)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	conn, err := DialContextWithOptions(ctx, "tcp4", l.Addr().String(), &DialOptions{FastOpen: true})
+	// the dial context being canceled mustn't affect the deferred connect
+	cancel()
+	require.NoError(t, err)
+	defer conn.Close()
+	assert.Nil(t, conn.(WrappedConn).Wrapped(), "shouldn't connect before first write")
+	assert.Equal(t, l.Addr().String(), conn.RemoteAddr().String())
+
+	// reads wait for the first write
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(50*time.Millisecond)))
+	_, err = conn.Read(make([]byte, 1))
+	assert.ErrorIs(t, err, os.ErrDeadlineExceeded)
+	require.NoError(t, conn.SetReadDeadline(time.Time{}))
+
+	_, err = conn.Write([]byte("hello"))
+	require.NoError(t, err)
+	buf := make([]byte, 5)
+	_, err = io.ReadFull(conn, buf)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(buf))
+	assert.NotNil(t, conn.(WrappedConn).Wrapped())
+	if runtime.GOOS != "linux" {
+		assert.False(t, TCPFeaturesOf(conn).FastOpen)
+	}
+	t.Logf("TCP Fast Open enabled: %v", TCPFeaturesOf(conn).FastOpen)
+}
+
+func TestFastOpenDeadlineWakesRead(t *testing.T) {
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	startEchoServer(l)
+
+	conn, err := DialContextWithOptions(context.Background(), "tcp4", l.Addr().String(), &DialOptions{FastOpen: true})
+	require.NoError(t, err)
+	defer conn.Close()
+
+	readErr := make(chan error, 1)
+	go func() {
+		_, err := conn.Read(make([]byte, 1))
+		readErr <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	require.NoError(t, conn.SetDeadline(time.Now()))
+	select {
+	case err := <-readErr:
+		assert.ErrorIs(t, err, os.ErrDeadlineExceeded)
+	case <-time.After(time.Second):
+		t.Fatal("changing the deadline should wake a Read waiting for the first Write")
+	}
+
+	// which lets BidiCopyContext stop copies from fast open conns
+	in, inPeer := net.Pipe()
+	defer in.Close()
+	defer inPeer.Close()
+	require.NoError(t, conn.SetDeadline(time.Time{}))
+	ctx, cancel := context.WithCancel(context.Background())
+	outErrCh, inErrCh := BidiCopyContext(ctx, conn, in, &CopyOpts{})
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+	assert.Equal(t, context.Canceled, <-outErrCh)
+	assert.Equal(t, context.Canceled, <-inErrCh)
+}
+
+func TestFastOpenBypassesNAT64Fallback(t *testing.T) {
+	nx := New()
+	prefix, _ := ParseNAT64Prefix("64:ff9b::/96")
+	nx.SetNAT64Prefix(prefix)
+	var dialed []string
+	nx.UseDialMiddleware(func(next DialFunc) DialFunc {
+		return func(ctx context.Context, network, addr string) (net.Conn, error) {
+			dialed = append(dialed, addr)
+			return next(ctx, network, addr)
+		}
+	})
+
+	conn, err := nx.DialContextWithOptions(context.Background(), "tcp", "93.184.216.34:443", &DialOptions{FastOpen: true})
+	require.NoError(t, err, "dialing the synthesized address succeeds until the first write")
+	defer conn.Close()
+	assert.Equal(t, []string{"[64:ff9b::5db8:d822]:443"}, dialed, "should not fall back to the original address")
+	assert.Equal(t, "[64:ff9b::5db8:d822]:443", conn.RemoteAddr().String())
+}
+
+func TestFastOpenConnectError(t *testing.T) {
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := l.Addr().String()
+	l.Close()
+
+	conn, err := DialContextWithOptions(context.Background(), "tcp4", addr, &DialOptions{FastOpen: true})
+	require.NoError(t, err, "connection errors should be deferred to the first write")
+	_, err = conn.Write([]byte("hello"))
+	assert.Error(t, err)
+	_, err = conn.Read(make([]byte, 1))
+	assert.Error(t, err)
+	assert.NoError(t, conn.Close())
+}
+
+func TestFastOpenCloseBeforeWrite(t *testing.T) {
+	conn, err := DialContextWithOptions(context.Background(), "tcp4", "127.0.0.1:1", &DialOptions{FastOpen: true})
+	require.NoError(t, err)
+	require.NoError(t, conn.Close())
+	_, err = conn.Write([]byte("hello"))
+	assert.ErrorIs(t, err, net.ErrClosed)
+}
+
+// hangingFastOpenConn returns a fastOpenConn whose connect hangs until it's
+// canceled, and a channel that's closed once connecting has started.
+func hangingFastOpenConn() (*fastOpenConn, <-chan struct{}) {
+	connecting := make(chan struct{})
+	dialer := net.Dialer{
+		ControlContext: func(ctx context.Context, network, address string, c syscall.RawConn) error {
+			close(connecting)
+			<-ctx.Done()
+			return ctx.Err()
+		},
+	}
+	return newFastOpenConn(context.Background(), dialer, "tcp4", "127.0.0.1:1"), connecting
+}
+
+func TestFastOpenCloseWhileConnecting(t *testing.T) {
+	conn, connecting := hangingFastOpenConn()
+	writeErr := make(chan error, 1)
+	go func() {
+		_, err := conn.Write([]byte("hello"))
+		writeErr <- err
+	}()
+	<-connecting
+
+	closed := make(chan error, 1)
+	go func() {
+		closed <- conn.Close()
+	}()
+	select {
+	case err := <-closed:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("Close shouldn't wait for the connect")
+	}
+	select {
+	case err := <-writeErr:
+		assert.ErrorIs(t, err, net.ErrClosed)
+	case <-time.After(time.Second):
+		t.Fatal("Close should cancel the connect")
+	}
+	_, err := conn.Read(make([]byte, 1))
+	assert.ErrorIs(t, err, net.ErrClosed)
+}
+
+func TestFastOpenWriteDeadlineWhileConnecting(t *testing.T) {
+	conn, connecting := hangingFastOpenConn()
+	defer conn.Close()
+	writeErr := make(chan error, 1)
+	go func() {
+		_, err := conn.Write([]byte("hello"))
+		writeErr <- err
+	}()
+	<-connecting
+
+	require.NoError(t, conn.SetDeadline(time.Now()))
+	select {
+	case err := <-writeErr:
+		assert.ErrorIs(t, err, os.ErrDeadlineExceeded)
+	case <-time.After(time.Second):
+		t.Fatal("the write deadline should cancel the connect")
+	}
+}
+
+func TestFastOpenReportsConnectOutcome(t *testing.T) {
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := l.Addr().String()
+	l.Close()
+
+	nx := New()
+	selector := &reportingIPSelector{}
+	nx.SetIPSelector(selector)
+	nx.EnableCircuitBreaker(&CircuitBreakerOpts{FailureThreshold: 1})
+	conn, err := nx.DialContextWithOptions(context.Background(), "tcp4", addr, &DialOptions{FastOpen: true})
+	require.NoError(t, err)
+	defer conn.Close()
+	assert.Empty(t, selector.reports, "the outcome isn't known before the first write")
+	assert.Equal(t, CircuitClosed, nx.CircuitStateOf(addr))
+
+	_, err = conn.Write([]byte("hello"))
+	require.Error(t, err)
+	assert.Equal(t, []string{"127.0.0.1 false"}, selector.reports)
+	assert.Equal(t, CircuitOpen, nx.CircuitStateOf(addr), "failing to connect should count as a failed dial")
+
+	// closing before connecting says nothing about the destination
+	nx.EnableCircuitBreaker(&CircuitBreakerOpts{FailureThreshold: 1})
+	selector.reports = nil
+	conn, err = nx.DialContextWithOptions(context.Background(), "tcp4", addr, &DialOptions{FastOpen: true})
+	require.NoError(t, err)
+	require.NoError(t, conn.Close())
+	assert.Empty(t, selector.reports)
+	assert.Equal(t, CircuitClosed, nx.CircuitStateOf(addr))
+}
+
+func TestMultipathTCP(t *testing.T) {
+	var lc net.ListenConfig
+	lc.SetMultipathTCP(true)
+	l, err := lc.Listen(context.Background(), "tcp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	startEchoServer(l)
+
+	conn, err := DialContextWithOptions(context.Background(), "tcp4", l.Addr().String(), &DialOptions{MultipathTCP: true})
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("hello"))
+	require.NoError(t, err)
+	buf := make([]byte, 5)
+	_, err = io.ReadFull(conn, buf)
+	require.NoError(t, err)
+
+	mptcp, _ := conn.(*net.TCPConn).MultipathTCP()
+	assert.Equal(t, mptcp, TCPFeaturesOf(conn).MultipathTCP)
+	t.Logf("Multipath TCP in use: %v", mptcp)
+
+	plain, err := DialContext(context.Background(), "tcp4", l.Addr().String())
+	require.NoError(t, err)
+	defer plain.Close()
+	assert.False(t, TCPFeaturesOf(plain).MultipathTCP)
+}
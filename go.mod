@@ -9,6 +9,7 @@ require (
 	github.com/getlantern/iptool v0.0.0-20230112135223-c00e863b2696
 	github.com/getlantern/mockconn v0.0.0-20200818071412-cb30d065a848
 	github.com/stretchr/testify v1.8.4
+	go.uber.org/goleak v1.2.0
 )
 
 require (
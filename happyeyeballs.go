@@ -0,0 +1,219 @@
+package netx
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/getlantern/errors"
+)
+
+const (
+	// DefaultHappyEyeballsDelay is the delay between connection attempts
+	// recommended by RFC 8305.
+	DefaultHappyEyeballsDelay = 250 * time.Millisecond
+)
+
+// EnableHappyEyeballs enables Happy Eyeballs (RFC 8305) dialing in the global
+// DialContext. See Netx.EnableHappyEyeballs.
+func EnableHappyEyeballs(attemptDelay time.Duration) {
+	defaultNetx.EnableHappyEyeballs(attemptDelay)
+}
+
+// EnableHappyEyeballs enables Happy Eyeballs (RFC 8305) dialing. Once enabled,
+// DialContext resolves host names on tcp networks using the configured
+// Resolver, interleaves IPv6 and IPv4 addresses (including any address
+// synthesized using the NAT64 prefix) and starts a new connection attempt every
+// attemptDelay until one succeeds. The first successful connection is returned
+// and all others are cancelled. If attemptDelay <= 0, DefaultHappyEyeballsDelay
+// is used.
+func (nx *Netx) EnableHappyEyeballs(attemptDelay time.Duration) {
+	if attemptDelay <= 0 {
+		attemptDelay = DefaultHappyEyeballsDelay
+	}
+	atomic.StoreInt64(&nx.happyEyeballsDelay, int64(attemptDelay))
+}
+
+// DisableHappyEyeballs disables Happy Eyeballs dialing in the global
+// DialContext.
+func DisableHappyEyeballs() {
+	defaultNetx.DisableHappyEyeballs()
+}
+
+// DisableHappyEyeballs disables Happy Eyeballs dialing.
+func (nx *Netx) DisableHappyEyeballs() {
+	atomic.StoreInt64(&nx.happyEyeballsDelay, 0)
+}
+
+// happyEyeballsEnabled returns the configured attempt delay and whether or not
+// Happy Eyeballs applies to the given network and address.
+func (nx *Netx) happyEyeballsEnabled(network string, addr string) (time.Duration, bool) {
+	delay := time.Duration(atomic.LoadInt64(&nx.happyEyeballsDelay))
+	if delay <= 0 {
+		return 0, false
+	}
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+	default:
+		return 0, false
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return 0, false
+	}
+	if ip, _ := parseIPZone(host); ip != nil {
+		// nothing to race for IP literals
+		return 0, false
+	}
+	return delay, true
+}
+
+type dialResult struct {
+	conn    net.Conn
+	attempt DialAttempt
+	target  dialTarget
+}
+
+// dialTarget is an address to try when dialing with Happy Eyeballs.
+type dialTarget struct {
+	addr        string
+	synthesized bool
+	// resolvedAddr is the resolved address that addr was synthesized from, or
+	// addr itself.
+	resolvedAddr string
+}
+
+func (nx *Netx) dialHappyEyeballs(ctx context.Context, network string, addr string, delay time.Duration) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, &DialError{Network: network, Addr: addr, Err: errors.New("Unable to parse addr %v: %v", addr, err)}
+	}
+	// always look up both families, since even tcp6 can use IPv4 addresses that
+	// have been synthesized using the NAT64 prefix
+	ips, err := nx.getResolver().LookupIP(ctx, "ip", host)
+	if err != nil {
+		return nil, &DialError{Network: network, Addr: addr, Err: errors.New("Unable to resolve IP for %v: %v", host, err)}
+	}
+	candidates := happyEyeballsAddrs(network, ips, port, nx.getNAT64Prefixes())
+	if len(candidates) == 0 {
+		// DialError reports that there were no addresses to try
+		return nil, &DialError{Network: network, Addr: addr}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	dialer := nx.getDialer()
+	results := make(chan dialResult, len(candidates))
+	next := 0
+	pending := 0
+	var timer *time.Timer
+	defer func() {
+		if timer != nil {
+			timer.Stop()
+		}
+	}()
+
+	startNext := func() {
+		candidate := candidates[next]
+		next++
+		pending++
+		go func() {
+			conn, attempt := dialAndRecord(ctx, dialer, network, addr, candidate.addr, candidate.synthesized)
+			results <- dialResult{conn, attempt, candidate}
+		}()
+		if timer != nil {
+			timer.Stop()
+			timer = nil
+		}
+		if next < len(candidates) {
+			timer = time.NewTimer(delay)
+		}
+	}
+
+	dialErr := &DialError{Network: network, Addr: addr}
+	startNext()
+	for pending > 0 {
+		var timerCh <-chan time.Time
+		if timer != nil {
+			timerCh = timer.C
+		}
+		select {
+		case result := <-results:
+			pending--
+			nx.reportDialOutcome(result.conn, result.attempt, result.target.resolvedAddr)
+			if result.attempt.Err == nil {
+				closeLosers(results, pending)
+				return result.conn, nil
+			}
+			dialErr.Attempts = append(dialErr.Attempts, result.attempt)
+			if next < len(candidates) && ctx.Err() == nil {
+				// don't wait for the delay if the prior attempt already failed
+				startNext()
+			}
+		case <-timerCh:
+			timer = nil
+			if ctx.Err() == nil {
+				startNext()
+			}
+		}
+	}
+	// n.b. once ctx is done, the pending attempts fail promptly and are recorded
+	// in dialErr like any other failure.
+	return nil, dialErr
+}
+
+// closeLosers waits in the background for the remaining pending dial attempts
+// and closes any connections that they managed to establish.
+func closeLosers(results <-chan dialResult, pending int) {
+	if pending == 0 {
+		return
+	}
+	go func() {
+		for i := 0; i < pending; i++ {
+			result := <-results
+			if result.conn != nil {
+				result.conn.Close()
+			}
+		}
+	}()
+}
+
+// happyEyeballsAddrs builds the list of addresses to try, alternating between
+// IPv6 and IPv4 and starting with IPv6 as recommended by RFC 8305. IPv4
+// addresses for which NAT64 addresses can be synthesized are also tried over
+// IPv6 using each of the given prefixes.
+func happyEyeballsAddrs(network string, ips []net.IP, port string, prefixes []*NAT64Prefix) []dialTarget {
+	var ipv6Addrs, ipv4Addrs []dialTarget
+	for _, ip := range ips {
+		addr := net.JoinHostPort(ip.String(), port)
+		if ip.To4() == nil {
+			ipv6Addrs = append(ipv6Addrs, dialTarget{addr: addr, resolvedAddr: addr})
+			continue
+		}
+		for _, prefix := range prefixes {
+			if synthesized := convertAddressDNS64(prefix, addr); synthesized != addr {
+				ipv6Addrs = append(ipv6Addrs, dialTarget{addr: synthesized, synthesized: true, resolvedAddr: addr})
+			}
+		}
+		ipv4Addrs = append(ipv4Addrs, dialTarget{addr: addr, resolvedAddr: addr})
+	}
+	switch network {
+	case "tcp4":
+		ipv6Addrs = nil
+	case "tcp6":
+		ipv4Addrs = nil
+	}
+
+	addrs := make([]dialTarget, 0, len(ipv6Addrs)+len(ipv4Addrs))
+	for i := 0; i < len(ipv6Addrs) || i < len(ipv4Addrs); i++ {
+		if i < len(ipv6Addrs) {
+			addrs = append(addrs, ipv6Addrs[i])
+		}
+		if i < len(ipv4Addrs) {
+			addrs = append(addrs, ipv4Addrs[i])
+		}
+	}
+	return addrs
+}
@@ -0,0 +1,103 @@
+package netx
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func targetAddrs(targets []dialTarget) []string {
+	var addrs []string
+	for _, target := range targets {
+		addrs = append(addrs, target.addr)
+	}
+	return addrs
+}
+
+func TestHappyEyeballsAddrs(t *testing.T) {
+	ips := []net.IP{
+		net.ParseIP("2001:db8::1"),
+		net.ParseIP("2001:db8::2"),
+		net.ParseIP("93.184.216.34"),
+	}
+	prefix, _ := NewNAT64Prefix(net.ParseIP("64:ff9b::"), 96)
+	prefixes := []*NAT64Prefix{prefix}
+
+	assert.Equal(t, []string{
+		"[2001:db8::1]:80",
+		"93.184.216.34:80",
+		"[2001:db8::2]:80",
+		"[64:ff9b::5db8:d822]:80",
+	}, targetAddrs(happyEyeballsAddrs("tcp", ips, "80", prefixes)))
+	assert.Equal(t, []string{"93.184.216.34:80"}, targetAddrs(happyEyeballsAddrs("tcp4", ips, "80", prefixes)))
+	assert.Equal(t, []string{
+		"[2001:db8::1]:80",
+		"[2001:db8::2]:80",
+		"[64:ff9b::5db8:d822]:80",
+	}, targetAddrs(happyEyeballsAddrs("tcp6", ips, "80", prefixes)))
+}
+
+func TestHappyEyeballsFallsBackToIPv4(t *testing.T) {
+	nx := New()
+	nx.OverrideResolveIPs(func(host string) ([]net.IP, error) {
+		return []net.IP{net.ParseIP("2001:db8::1"), net.ParseIP("93.184.216.34")}, nil
+	})
+
+	var mx sync.Mutex
+	var attempted []string
+	ipv6Cancelled := make(chan struct{})
+	nx.OverrideDial(func(ctx context.Context, network, addr string) (net.Conn, error) {
+		mx.Lock()
+		attempted = append(attempted, addr)
+		mx.Unlock()
+		if addr == "[2001:db8::1]:443" {
+			// simulate a black-holed IPv6 address
+			<-ctx.Done()
+			close(ipv6Cancelled)
+			return nil, ctx.Err()
+		}
+		conn, _ := net.Pipe()
+		return conn, nil
+	})
+	nx.EnableHappyEyeballs(10 * time.Millisecond)
+
+	start := time.Now()
+	conn, err := nx.DialTimeout("tcp", "example.com:443", 10*time.Second)
+	require.NoError(t, err)
+	defer conn.Close()
+	assert.True(t, time.Since(start) < 5*time.Second, "should not have waited for the IPv6 attempt to time out")
+
+	select {
+	case <-ipv6Cancelled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("losing attempt should have been cancelled")
+	}
+	mx.Lock()
+	assert.Equal(t, []string{"[2001:db8::1]:443", "93.184.216.34:443"}, attempted)
+	mx.Unlock()
+}
+
+func TestHappyEyeballsAllFail(t *testing.T) {
+	nx := New()
+	nx.OverrideResolveIPs(func(host string) ([]net.IP, error) {
+		return []net.IP{net.ParseIP("2001:db8::1"), net.ParseIP("93.184.216.34")}, nil
+	})
+	nx.OverrideDial(func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return nil, &net.OpError{Op: "dial", Net: network, Err: assert.AnError}
+	})
+	nx.EnableHappyEyeballs(time.Hour)
+
+	_, err := nx.DialTimeout("tcp", "example.com:443", 10*time.Second)
+	require.Error(t, err)
+	dialErr, ok := err.(*DialError)
+	require.True(t, ok)
+	require.Len(t, dialErr.Attempts, 2)
+	assert.Equal(t, "[2001:db8::1]:443", dialErr.Attempts[0].Addr)
+	assert.Equal(t, "93.184.216.34:443", dialErr.Attempts[1].Addr)
+	assert.ErrorIs(t, err, assert.AnError)
+}
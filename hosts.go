@@ -0,0 +1,78 @@
+package netx
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"strings"
+)
+
+// SetHostsOverride sets static answers for the global resolution. See
+// Netx.SetHostsOverride.
+func SetHostsOverride(hosts map[string][]net.IP) {
+	defaultNetx.SetHostsOverride(hosts)
+}
+
+// SetHostsOverride sets static answers for host names, like /etc/hosts does.
+// All resolution by this Netx consults these first, before any middleware or
+// the configured Resolver, and doesn't look up hosts that are listed even if
+// none of their IPs match the requested family. Host names are matched
+// case-insensitively and without any trailing dot. A nil or empty map removes
+// all overrides.
+func (nx *Netx) SetHostsOverride(hosts map[string][]net.IP) {
+	normalized := make(map[string][]net.IP, len(hosts))
+	for host, ips := range hosts {
+		normalized[normalizeHost(host)] = append([]net.IP(nil), ips...)
+	}
+	nx.hostsOverride.Store(normalized)
+}
+
+func (nx *Netx) getHostsOverride() map[string][]net.IP {
+	hosts, _ := nx.hostsOverride.Load().(map[string][]net.IP)
+	return hosts
+}
+
+// isHostOverridden returns true if the host in addr is in the hosts override.
+func (nx *Netx) isHostOverridden(addr string) bool {
+	hosts := nx.getHostsOverride()
+	if len(hosts) == 0 {
+		return false
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	_, found := hosts[normalizeHost(host)]
+	return found
+}
+
+func normalizeHost(host string) string {
+	return strings.ToLower(strings.TrimSuffix(host, "."))
+}
+
+// parseIPZone parses host as an IP literal with an optional IPv6 zone, like
+// fe80::1%eth0. It returns a nil IP if host isn't an IP literal.
+func parseIPZone(host string) (net.IP, string) {
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return nil, ""
+	}
+	return net.IP(addr.Unmap().AsSlice()), addr.Zone()
+}
+
+// staticResolver answers lookups for IP literals and overridden hosts itself
+// and passes everything else to next.
+type staticResolver struct {
+	hosts map[string][]net.IP
+	next  Resolver
+}
+
+func (r *staticResolver) LookupIP(ctx context.Context, network, host string) ([]net.IP, error) {
+	if ip, _ := parseIPZone(host); ip != nil {
+		return filterIPsForNetwork(network, []net.IP{ip}), nil
+	}
+	if ips, found := r.hosts[normalizeHost(host)]; found {
+		return filterIPsForNetwork(network, ips), nil
+	}
+	return r.next.LookupIP(ctx, network, host)
+}
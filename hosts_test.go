@@ -0,0 +1,88 @@
+package netx
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolveIPLiterals(t *testing.T) {
+	nx := New()
+	var lookups int
+	nx.OverrideResolveIPs(func(host string) ([]net.IP, error) {
+		lookups++
+		return nil, assert.AnError
+	})
+
+	addr, err := nx.Resolve("tcp", "1.2.3.4:80")
+	require.NoError(t, err)
+	assert.Equal(t, "1.2.3.4:80", addr.String())
+
+	addr, err = nx.Resolve("tcp6", "[fe80::1%eth0]:80")
+	require.NoError(t, err)
+	assert.Equal(t, "eth0", addr.Zone)
+	assert.Equal(t, "[fe80::1%eth0]:80", addr.String())
+
+	udpAddr, err := nx.ResolveUDPAddr("udp", "[fe80::1%2]:53")
+	require.NoError(t, err)
+	assert.Equal(t, "2", udpAddr.Zone)
+
+	tcpAddrs, err := nx.ResolveAllTCP("tcp", "[fe80::1%eth0]:80")
+	require.NoError(t, err)
+	require.Len(t, tcpAddrs, 1)
+	assert.Equal(t, "eth0", tcpAddrs[0].Zone)
+
+	_, err = nx.Resolve("tcp4", "[2001:db8::1]:80")
+	assert.Error(t, err, "IPv6 literal shouldn't resolve on tcp4")
+	assert.Equal(t, 0, lookups)
+}
+
+func TestHostsOverride(t *testing.T) {
+	nx := New()
+	var lookups []string
+	nx.OverrideResolveIPs(func(host string) ([]net.IP, error) {
+		lookups = append(lookups, host)
+		return parseIPs("9.9.9.9"), nil
+	})
+	nx.SetHostsOverride(map[string][]net.IP{
+		"Pinned.Example.": parseIPs("1.1.1.1"),
+		"v6only.example":  parseIPs("2001:db8::1"),
+		"ipv4only.arpa":   parseIPs("64:ff9b::c000:aa"),
+	})
+
+	addr, err := nx.Resolve("tcp", "pinned.example:80")
+	require.NoError(t, err)
+	assert.Equal(t, "1.1.1.1:80", addr.String())
+
+	_, err = nx.Resolve("tcp4", "v6only.example:80")
+	assert.Error(t, err, "listed hosts shouldn't be looked up")
+
+	addr, err = nx.Resolve("tcp", "unlisted.example:80")
+	require.NoError(t, err)
+	assert.Equal(t, "9.9.9.9:80", addr.String())
+	assert.Equal(t, []string{"unlisted.example"}, lookups)
+
+	// the override also applies to NAT64 discovery
+	nx.updateNAT64Prefix(context.Background(), DefaultNAT64DiscoveryHost)
+	assert.Equal(t, "64:ff9b::/96", nx.NAT64Status().Prefix.String())
+	nx.resetNAT64()
+
+	// and to dialing
+	var dialed string
+	nx.OverrideDial(func(ctx context.Context, network, addr string) (net.Conn, error) {
+		dialed = addr
+		return nil, assert.AnError
+	})
+	_, _ = nx.DialContext(context.Background(), "tcp", "pinned.example:443")
+	assert.Equal(t, "1.1.1.1:443", dialed)
+	_, _ = nx.DialContext(context.Background(), "tcp", "unlisted.example:443")
+	assert.Equal(t, "unlisted.example:443", dialed)
+
+	nx.SetHostsOverride(nil)
+	addr, err = nx.Resolve("tcp", "pinned.example:80")
+	require.NoError(t, err)
+	assert.Equal(t, "9.9.9.9:80", addr.String())
+}
@@ -0,0 +1,226 @@
+package netx
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+// IPSelector picks which of the IP addresses that a host resolved to should
+// be used.
+type IPSelector interface {
+	// SelectIP picks one of ips, which is never empty, for the given host.
+	SelectIP(host string, ips []net.IP) net.IP
+}
+
+// DialOutcomeReporter is implemented by IPSelectors that want to learn whether
+// dialing the IPs that they selected succeeded.
+type DialOutcomeReporter interface {
+	// ReportDialOutcome is called after dialing ip with the resulting error, or
+	// nil if dialing succeeded. Dials that were canceled aren't reported.
+	ReportDialOutcome(ip net.IP, err error)
+}
+
+// SetIPSelector sets the global IPSelector. See Netx.SetIPSelector.
+func SetIPSelector(selector IPSelector) {
+	defaultNetx.SetIPSelector(selector)
+}
+
+// SetIPSelector sets the IPSelector used by Resolve and ResolveUDPAddr. Once
+// set, DialContext also resolves host names itself and dials the selected IP
+// instead of leaving resolution to the dial function, and reports the outcome
+// if selector is a DialOutcomeReporter. Happy Eyeballs dialing still tries all
+// IPs, but reports their outcomes too. A nil selector restores the default,
+// which picks a random IP and leaves DialContext alone.
+func (nx *Netx) SetIPSelector(selector IPSelector) {
+	nx.ipSelector.Store(ipSelectorHolder{selector})
+}
+
+// ipSelectorHolder allows storing different IPSelector implementations, or
+// none, in the same atomic.Value.
+type ipSelectorHolder struct {
+	IPSelector
+}
+
+// getIPSelector returns the configured IPSelector, or nil if none is set.
+func (nx *Netx) getIPSelector() IPSelector {
+	holder, _ := nx.ipSelector.Load().(ipSelectorHolder)
+	return holder.IPSelector
+}
+
+func (nx *Netx) selectIP(host string, ips []net.IP) net.IP {
+	if selector := nx.getIPSelector(); selector != nil {
+		return selector.SelectIP(host, ips)
+	}
+	return randomIPSelector{}.SelectIP(host, ips)
+}
+
+// reportDialOutcome tells the IPSelector how the given attempt, which returned
+// conn, went if it wants to know. The outcome is reported for the IP in
+// resolvedAddr, which is the address that was resolved and selected before any
+// NAT64 synthesis. Failures to dial synthesized addresses aren't reported,
+// since they say nothing about the selected IP and the original address is
+// tried as well. For conns that defer connecting, see whenConnected.
+func (nx *Netx) reportDialOutcome(conn net.Conn, attempt DialAttempt, resolvedAddr string) {
+	reporter, ok := nx.getIPSelector().(DialOutcomeReporter)
+	if !ok {
+		return
+	}
+	host, _, err := net.SplitHostPort(resolvedAddr)
+	if err != nil {
+		return
+	}
+	ip, _ := parseIPZone(host)
+	if ip == nil {
+		return
+	}
+	whenConnected(conn, attempt.Err, func(err error) {
+		if errors.Is(err, context.Canceled) || (attempt.Synthesized && err != nil) {
+			return
+		}
+		reporter.ReportDialOutcome(ip, err)
+	})
+}
+
+// RandomIPSelector returns an IPSelector that picks a random IP each time.
+func RandomIPSelector() IPSelector {
+	return randomIPSelector{}
+}
+
+type randomIPSelector struct{}
+
+func (randomIPSelector) SelectIP(host string, ips []net.IP) net.IP {
+	ip, _ := pickRandomIP(ips)
+	return ip
+}
+
+// maxRoundRobinHosts is how many hosts NewRoundRobinIPSelector keeps track of.
+// Once exceeded, the least recently used host starts over with its first IP.
+const maxRoundRobinHosts = 1000
+
+// NewRoundRobinIPSelector returns an IPSelector that cycles through each host's
+// IPs in turn.
+func NewRoundRobinIPSelector() IPSelector {
+	return &roundRobinIPSelector{
+		hosts: make(map[string]*list.Element),
+		lru:   list.New(),
+	}
+}
+
+type roundRobinIPSelector struct {
+	hosts map[string]*list.Element
+	lru   *list.List
+	mx    sync.Mutex
+}
+
+type roundRobinHost struct {
+	host string
+	next int
+}
+
+func (s *roundRobinIPSelector) SelectIP(host string, ips []net.IP) net.IP {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	el, found := s.hosts[host]
+	if found {
+		s.lru.MoveToFront(el)
+	} else {
+		el = s.lru.PushFront(&roundRobinHost{host: host})
+		s.hosts[host] = el
+		for s.lru.Len() > maxRoundRobinHosts {
+			oldest := s.lru.Back()
+			s.lru.Remove(oldest)
+			delete(s.hosts, oldest.Value.(*roundRobinHost).host)
+		}
+	}
+	h := el.Value.(*roundRobinHost)
+	i := h.next % len(ips)
+	h.next = (i + 1) % len(ips)
+	return ips[i]
+}
+
+// RFC6724IPSelector returns an IPSelector that picks the first IP in the order
+// given by the destination address selection rules of RFC 6724.
+func RFC6724IPSelector() IPSelector {
+	return rfc6724IPSelector{}
+}
+
+type rfc6724IPSelector struct{}
+
+func (rfc6724IPSelector) SelectIP(host string, ips []net.IP) net.IP {
+	sorted := append([]net.IP(nil), ips...)
+	sortByRFC6724(sorted)
+	return sorted[0]
+}
+
+// DefaultFailureMemory is how long NewLeastRecentlyFailedIPSelector remembers
+// failures by default.
+const DefaultFailureMemory = 10 * time.Minute
+
+// NewLeastRecentlyFailedIPSelector returns an IPSelector that picks a random IP
+// among those that haven't failed to dial within the last failureMemory. If
+// they all have, it picks the one that failed longest ago. A successful dial
+// forgets prior failures. If failureMemory <= 0, DefaultFailureMemory is used.
+func NewLeastRecentlyFailedIPSelector(failureMemory time.Duration) IPSelector {
+	if failureMemory <= 0 {
+		failureMemory = DefaultFailureMemory
+	}
+	return &leastRecentlyFailedIPSelector{
+		failureMemory: failureMemory,
+		failed:        make(map[string]time.Time),
+		now:           time.Now,
+	}
+}
+
+type leastRecentlyFailedIPSelector struct {
+	failureMemory time.Duration
+	failed        map[string]time.Time
+	now           func() time.Time
+	mx            sync.Mutex
+}
+
+func (s *leastRecentlyFailedIPSelector) SelectIP(host string, ips []net.IP) net.IP {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	cutoff := s.now().Add(-s.failureMemory)
+	var healthy []net.IP
+	var oldest net.IP
+	var oldestFailure time.Time
+	for _, ip := range ips {
+		failure, found := s.failed[ip.String()]
+		if !found || failure.Before(cutoff) {
+			healthy = append(healthy, ip)
+			continue
+		}
+		if oldest == nil || failure.Before(oldestFailure) {
+			oldest = ip
+			oldestFailure = failure
+		}
+	}
+	if len(healthy) > 0 {
+		ip, _ := pickRandomIP(healthy)
+		return ip
+	}
+	return oldest
+}
+
+func (s *leastRecentlyFailedIPSelector) ReportDialOutcome(ip net.IP, err error) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	if err == nil {
+		delete(s.failed, ip.String())
+		return
+	}
+	now := s.now()
+	s.failed[ip.String()] = now
+	// forget old failures so that the map doesn't grow indefinitely
+	cutoff := now.Add(-s.failureMemory)
+	for key, failure := range s.failed {
+		if failure.Before(cutoff) {
+			delete(s.failed, key)
+		}
+	}
+}
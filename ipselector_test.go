@@ -0,0 +1,206 @@
+package netx
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func parseIPs(addrs ...string) []net.IP {
+	ips := make([]net.IP, 0, len(addrs))
+	for _, addr := range addrs {
+		ips = append(ips, net.ParseIP(addr))
+	}
+	return ips
+}
+
+func TestSortByRFC6724(t *testing.T) {
+	// These are based on the examples in RFC 6724 section 10.2.
+	tests := []struct {
+		dsts     []string
+		srcs     map[string]string
+		expected []string
+	}{
+		{
+			[]string{"198.51.100.121", "2001:db8:1::1"},
+			map[string]string{"2001:db8:1::1": "2001:db8:1::2", "198.51.100.121": "169.254.13.78"},
+			[]string{"2001:db8:1::1", "198.51.100.121"},
+		},
+		{
+			[]string{"2001:db8:1::1", "198.51.100.121"},
+			map[string]string{"2001:db8:1::1": "fe80::1", "198.51.100.121": "198.51.100.117"},
+			[]string{"198.51.100.121", "2001:db8:1::1"},
+		},
+		{
+			[]string{"10.1.2.3", "2001:db8:1::1"},
+			map[string]string{"2001:db8:1::1": "2001:db8:1::2", "10.1.2.3": "10.1.2.4"},
+			[]string{"2001:db8:1::1", "10.1.2.3"},
+		},
+		{
+			[]string{"2001:db8:1::1", "fe80::1"},
+			map[string]string{"2001:db8:1::1": "2001:db8:1::2", "fe80::1": "fe80::2"},
+			[]string{"fe80::1", "2001:db8:1::1"},
+		},
+		{
+			[]string{"2001:db8:1::1", "2001:db8:3ffe::1"},
+			map[string]string{"2001:db8:1::1": "2001:db8:3f44::2", "2001:db8:3ffe::1": "2001:db8:3f44::2"},
+			[]string{"2001:db8:3ffe::1", "2001:db8:1::1"},
+		},
+		{
+			[]string{"2001:db8:1::1", "198.51.100.121"},
+			map[string]string{"198.51.100.121": "198.51.100.117"},
+			[]string{"198.51.100.121", "2001:db8:1::1"},
+		},
+	}
+	for _, test := range tests {
+		ips := parseIPs(test.dsts...)
+		sortByRFC6724Using(ips, func(dst net.IP) net.IP {
+			return net.ParseIP(test.srcs[dst.String()])
+		})
+		assert.Equal(t, parseIPs(test.expected...), ips)
+	}
+}
+
+func TestRoundRobinIPSelector(t *testing.T) {
+	selector := NewRoundRobinIPSelector()
+	ips := parseIPs("1.1.1.1", "2.2.2.2", "3.3.3.3")
+	var selected []string
+	for i := 0; i < 4; i++ {
+		selected = append(selected, selector.SelectIP("a", ips).String())
+	}
+	assert.Equal(t, []string{"1.1.1.1", "2.2.2.2", "3.3.3.3", "1.1.1.1"}, selected)
+	assert.Equal(t, "1.1.1.1", selector.SelectIP("b", ips).String(), "hosts should be independent")
+
+	for i := 0; i < maxRoundRobinHosts*2; i++ {
+		selector.SelectIP(fmt.Sprintf("host%d", i), ips)
+	}
+	rr := selector.(*roundRobinIPSelector)
+	assert.Len(t, rr.hosts, maxRoundRobinHosts, "should only keep track of the most recently used hosts")
+	assert.Equal(t, maxRoundRobinHosts, rr.lru.Len())
+	assert.Equal(t, "1.1.1.1", selector.SelectIP("a", ips).String(), "evicted host should start over")
+}
+
+func TestLeastRecentlyFailedIPSelector(t *testing.T) {
+	clock := &fakeClock{now: time.Now()}
+	selector := NewLeastRecentlyFailedIPSelector(time.Minute).(*leastRecentlyFailedIPSelector)
+	selector.now = clock.Now
+	ips := parseIPs("1.1.1.1", "2.2.2.2")
+
+	selector.ReportDialOutcome(ips[0], assert.AnError)
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, "2.2.2.2", selector.SelectIP("a", ips).String())
+	}
+
+	clock.Advance(time.Second)
+	selector.ReportDialOutcome(ips[1], assert.AnError)
+	assert.Equal(t, "1.1.1.1", selector.SelectIP("a", ips).String(), "should pick the one that failed longest ago")
+
+	selector.ReportDialOutcome(ips[1], nil)
+	assert.Equal(t, "2.2.2.2", selector.SelectIP("a", ips).String(), "success should forget failures")
+
+	clock.Advance(2 * time.Minute)
+	selector.ReportDialOutcome(ips[1], assert.AnError)
+	assert.Equal(t, "1.1.1.1", selector.SelectIP("a", ips).String(), "old failures should be forgotten")
+	assert.Len(t, selector.failed, 1)
+}
+
+func TestIPSelectorSharedByResolveAndDial(t *testing.T) {
+	nx := New()
+	nx.OverrideResolveIPs(func(host string) ([]net.IP, error) {
+		return parseIPs("1.1.1.1", "2.2.2.2"), nil
+	})
+	var dialed []string
+	nx.OverrideDial(func(ctx context.Context, network, addr string) (net.Conn, error) {
+		dialed = append(dialed, addr)
+		if addr == "1.1.1.1:80" {
+			return nil, assert.AnError
+		}
+		return &net.TCPConn{}, nil
+	})
+	nx.SetIPSelector(NewLeastRecentlyFailedIPSelector(0))
+
+	// without failures, both IPs get picked
+	seen := make(map[string]bool)
+	for i := 0; i < 100 && len(seen) < 2; i++ {
+		addr, err := nx.Resolve("tcp", "example.com:80")
+		require.NoError(t, err)
+		seen[addr.String()] = true
+	}
+	assert.Len(t, seen, 2)
+
+	failed := false
+	for i := 0; i < 100 && !failed; i++ {
+		_, err := nx.DialContext(context.Background(), "tcp", "example.com:80")
+		failed = err != nil
+	}
+	require.True(t, failed)
+	require.Equal(t, "1.1.1.1:80", dialed[len(dialed)-1])
+
+	// after the failure, only the other IP gets picked
+	for i := 0; i < 10; i++ {
+		addr, err := nx.Resolve("tcp", "example.com:80")
+		require.NoError(t, err)
+		assert.Equal(t, "2.2.2.2:80", addr.String())
+		_, err = nx.DialContext(context.Background(), "tcp", "example.com:80")
+		assert.NoError(t, err)
+		assert.Equal(t, "2.2.2.2:80", dialed[len(dialed)-1])
+	}
+
+	nx.SetIPSelector(nil)
+	_, _ = nx.DialContext(context.Background(), "tcp", "example.com:80")
+	assert.Equal(t, "example.com:80", dialed[len(dialed)-1], "without a selector, the dial function should resolve")
+}
+
+type reportingIPSelector struct {
+	reports []string
+}
+
+func (s *reportingIPSelector) SelectIP(host string, ips []net.IP) net.IP {
+	return ips[0]
+}
+
+func (s *reportingIPSelector) ReportDialOutcome(ip net.IP, err error) {
+	s.reports = append(s.reports, fmt.Sprintf("%v %v", ip, err == nil))
+}
+
+func TestDialOutcomeReportedForSelectedIP(t *testing.T) {
+	nx := New()
+	prefix, _ := ParseNAT64Prefix("64:ff9b::/96")
+	nx.SetNAT64Prefix(prefix)
+	nx.OverrideResolveIPs(func(host string) ([]net.IP, error) {
+		return parseIPs("93.184.216.34"), nil
+	})
+	synthesizedFails := false
+	nx.OverrideDial(func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if synthesizedFails && addr == "[64:ff9b::5db8:d822]:80" {
+			return nil, assert.AnError
+		}
+		return &net.TCPConn{}, nil
+	})
+	selector := &reportingIPSelector{}
+	nx.SetIPSelector(selector)
+
+	_, err := nx.DialContext(context.Background(), "tcp", "example.com:80")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"93.184.216.34 true"}, selector.reports, "success via the synthesized address should count for the selected IP")
+
+	selector.reports = nil
+	synthesizedFails = true
+	_, err = nx.DialContext(context.Background(), "tcp", "example.com:80")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"93.184.216.34 true"}, selector.reports, "only the fallback's outcome should be reported")
+
+	selector.reports = nil
+	nx.EnableHappyEyeballs(time.Millisecond)
+	_, err = nx.DialContext(context.Background(), "tcp", "example.com:80")
+	require.NoError(t, err)
+	assert.NotEmpty(t, selector.reports)
+	for _, report := range selector.reports {
+		assert.Equal(t, "93.184.216.34 true", report)
+	}
+}
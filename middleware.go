@@ -0,0 +1,194 @@
+package netx
+
+import (
+	"context"
+	"net"
+	"sync"
+	"sync/atomic"
+)
+
+// DialFunc is a function that dials like net.Dialer.DialContext.
+type DialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// DialUDPFunc is a function that dials like net.DialUDP but with a context.
+type DialUDPFunc func(ctx context.Context, network string, laddr, raddr *net.UDPAddr) (*net.UDPConn, error)
+
+// ListenPacketFunc is a function that listens like net.ListenConfig.ListenPacket.
+type ListenPacketFunc func(ctx context.Context, network string, addr string) (net.PacketConn, error)
+
+// DialMiddleware wraps a DialFunc, for example to add logging or metrics. It
+// should call next to actually dial.
+type DialMiddleware func(next DialFunc) DialFunc
+
+// DialUDPMiddleware wraps a DialUDPFunc.
+type DialUDPMiddleware func(next DialUDPFunc) DialUDPFunc
+
+// ListenPacketMiddleware wraps a ListenPacketFunc.
+type ListenPacketMiddleware func(next ListenPacketFunc) ListenPacketFunc
+
+// ResolverMiddleware wraps a Resolver.
+type ResolverMiddleware func(next Resolver) Resolver
+
+// UseDialMiddleware adds middleware around the global dial function. See
+// Netx.UseDialMiddleware.
+func UseDialMiddleware(mw DialMiddleware) (remove func()) {
+	return defaultNetx.UseDialMiddleware(mw)
+}
+
+// UseDialMiddleware adds middleware around this Netx's dial function. It
+// applies to every individual dial, including each address that's tried when
+// falling back from a NAT64 address or when using happy eyeballs.
+//
+// Middleware is applied around the function configured with OverrideDial, so
+// overriding that keeps all middleware in place. Middleware is applied in the
+// order in which it was added, with the first middleware being the outermost,
+// so it sees calls first and results last. The returned function removes the
+// middleware again. Reset removes all middleware.
+//
+// Each middleware is called to wrap the next function only when middleware is
+// added or removed or the underlying function is overridden, not for every
+// dial, so any state it sets up when wrapping is kept across calls.
+//
+// The other Use functions behave the same way.
+func (nx *Netx) UseDialMiddleware(mw DialMiddleware) (remove func()) {
+	return nx.dialMiddleware.use(mw)
+}
+
+// UseDialUDPMiddleware adds middleware around the global dialUDP function.
+func UseDialUDPMiddleware(mw DialUDPMiddleware) (remove func()) {
+	return defaultNetx.UseDialUDPMiddleware(mw)
+}
+
+// UseDialUDPMiddleware adds middleware around this Netx's dialUDP function.
+func (nx *Netx) UseDialUDPMiddleware(mw DialUDPMiddleware) (remove func()) {
+	return nx.dialUDPMiddleware.use(mw)
+}
+
+// UseListenPacketMiddleware adds middleware around the global listenPacket
+// function.
+func UseListenPacketMiddleware(mw ListenPacketMiddleware) (remove func()) {
+	return defaultNetx.UseListenPacketMiddleware(mw)
+}
+
+// UseListenPacketMiddleware adds middleware around this Netx's listenPacket
+// function, which ListenUDP uses too.
+func (nx *Netx) UseListenPacketMiddleware(mw ListenPacketMiddleware) (remove func()) {
+	return nx.listenMiddleware.use(mw)
+}
+
+// UseResolverMiddleware adds middleware around the global Resolver.
+func UseResolverMiddleware(mw ResolverMiddleware) (remove func()) {
+	return defaultNetx.UseResolverMiddleware(mw)
+}
+
+// UseResolverMiddleware adds middleware around this Netx's Resolver. It applies
+// to all lookups, including those for NAT64 prefix discovery.
+func (nx *Netx) UseResolverMiddleware(mw ResolverMiddleware) (remove func()) {
+	return nx.resolverMiddleware.use(mw)
+}
+
+func (nx *Netx) getDialer() DialFunc {
+	return nx.dialMiddleware.get()
+}
+
+func (nx *Netx) getDialUDP() DialUDPFunc {
+	return nx.dialUDPMiddleware.get()
+}
+
+func (nx *Netx) getListenPacket() ListenPacketFunc {
+	return nx.listenMiddleware.get()
+}
+
+func (nx *Netx) resetMiddleware() {
+	nx.dialMiddleware.reset()
+	nx.dialUDPMiddleware.reset()
+	nx.listenMiddleware.reset()
+	nx.resolverMiddleware.reset()
+}
+
+type middlewareEntry[T any] struct {
+	id int
+	mw func(T) T
+}
+
+// middlewareSnapshot is the state of a middlewareChain.
+type middlewareSnapshot[T any] struct {
+	base     T
+	composed T
+}
+
+// middlewareChain is a base function or Resolver wrapped in a list of
+// middleware. Changing the base or the middleware is serialized and composes
+// them right away, so that getting the composed function doesn't have to lock
+// and middleware is only instantiated once per change rather than for every
+// call.
+type middlewareChain[T any] struct {
+	snapshot atomic.Value // middlewareSnapshot[T]
+	base     T
+	entries  []middlewareEntry[T]
+	nextID   int
+	mx       sync.Mutex
+}
+
+func (c *middlewareChain[T]) setBase(base T) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	c.base = base
+	c.compose()
+}
+
+func (c *middlewareChain[T]) use(mw func(T) T) func() {
+	c.mx.Lock()
+	id := c.nextID
+	c.nextID++
+	c.entries = append(c.entries[:len(c.entries):len(c.entries)], middlewareEntry[T]{id, mw})
+	c.compose()
+	c.mx.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			c.remove(id)
+		})
+	}
+}
+
+func (c *middlewareChain[T]) remove(id int) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	entries := make([]middlewareEntry[T], 0, len(c.entries))
+	for _, entry := range c.entries {
+		if entry.id != id {
+			entries = append(entries, entry)
+		}
+	}
+	c.entries = entries
+	c.compose()
+}
+
+func (c *middlewareChain[T]) reset() {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	c.entries = nil
+	c.compose()
+}
+
+// compose wraps the base in all middleware, innermost last, and stores the
+// result. It must be called with c.mx held.
+func (c *middlewareChain[T]) compose() {
+	composed := c.base
+	for i := len(c.entries) - 1; i >= 0; i-- {
+		composed = c.entries[i].mw(composed)
+	}
+	c.snapshot.Store(middlewareSnapshot[T]{base: c.base, composed: composed})
+}
+
+// get returns the base wrapped in all middleware.
+func (c *middlewareChain[T]) get() T {
+	return c.snapshot.Load().(middlewareSnapshot[T]).composed
+}
+
+// getBase returns the base without any middleware.
+func (c *middlewareChain[T]) getBase() T {
+	return c.snapshot.Load().(middlewareSnapshot[T]).base
+}
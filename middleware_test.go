@@ -0,0 +1,147 @@
+package netx
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDialMiddlewareOrderAndRemoval(t *testing.T) {
+	var calls []string
+	nx := New()
+	nx.OverrideDial(func(ctx context.Context, network, addr string) (net.Conn, error) {
+		calls = append(calls, "dial "+addr)
+		return nil, assert.AnError
+	})
+	recorder := func(name string) DialMiddleware {
+		return func(next DialFunc) DialFunc {
+			return func(ctx context.Context, network, addr string) (net.Conn, error) {
+				calls = append(calls, name+" before")
+				conn, err := next(ctx, network, addr)
+				calls = append(calls, name+" after")
+				return conn, err
+			}
+		}
+	}
+	removeFirst := nx.UseDialMiddleware(recorder("first"))
+	removeSecond := nx.UseDialMiddleware(recorder("second"))
+
+	_, err := nx.DialContext(context.Background(), "tcp", "127.0.0.1:1")
+	require.Error(t, err)
+	assert.Equal(t, []string{"first before", "second before", "dial 127.0.0.1:1", "second after", "first after"}, calls)
+
+	// overriding the dial function keeps middleware in place
+	nx.OverrideDial(func(ctx context.Context, network, addr string) (net.Conn, error) {
+		calls = append(calls, "override "+addr)
+		return nil, assert.AnError
+	})
+	calls = nil
+	removeFirst()
+	removeFirst()
+	_, _ = nx.DialContext(context.Background(), "tcp", "127.0.0.1:1")
+	assert.Equal(t, []string{"second before", "override 127.0.0.1:1", "second after"}, calls)
+
+	calls = nil
+	removeSecond()
+	_, _ = nx.DialContext(context.Background(), "tcp", "127.0.0.1:1")
+	assert.Equal(t, []string{"override 127.0.0.1:1"}, calls)
+}
+
+func TestUDPMiddleware(t *testing.T) {
+	nx := New()
+	var listened, dialed int
+	nx.UseListenPacketMiddleware(func(next ListenPacketFunc) ListenPacketFunc {
+		return func(ctx context.Context, network string, addr string) (net.PacketConn, error) {
+			listened++
+			return next(ctx, network, addr)
+		}
+	})
+	nx.UseDialUDPMiddleware(func(next DialUDPFunc) DialUDPFunc {
+		return func(ctx context.Context, network string, laddr, raddr *net.UDPAddr) (*net.UDPConn, error) {
+			dialed++
+			return next(ctx, network, laddr, raddr)
+		}
+	})
+
+	l, err := nx.ListenUDP("udp4", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	require.NoError(t, err)
+	defer l.Close()
+	conn, err := nx.DialUDP("udp4", nil, l.LocalAddr().(*net.UDPAddr))
+	require.NoError(t, err)
+	conn.Close()
+	assert.Equal(t, 1, listened)
+	assert.Equal(t, 1, dialed)
+
+	nx.Reset()
+	conn, err = nx.DialUDP("udp4", nil, l.LocalAddr().(*net.UDPAddr))
+	require.NoError(t, err)
+	conn.Close()
+	assert.Equal(t, 1, dialed, "Reset should remove middleware")
+}
+
+type rewritingResolver struct {
+	next Resolver
+}
+
+func (r *rewritingResolver) LookupIP(ctx context.Context, network, host string) ([]net.IP, error) {
+	if host == "rewritten.example" {
+		return []net.IP{net.ParseIP("3.3.3.3")}, nil
+	}
+	return r.next.LookupIP(ctx, network, host)
+}
+
+func TestResolverMiddleware(t *testing.T) {
+	nx := New()
+	nx.OverrideResolveIPs(func(host string) ([]net.IP, error) {
+		return []net.IP{net.ParseIP("1.1.1.1")}, nil
+	})
+	remove := nx.UseResolverMiddleware(func(next Resolver) Resolver {
+		return &rewritingResolver{next}
+	})
+
+	addr, err := nx.Resolve("tcp", "rewritten.example:80")
+	require.NoError(t, err)
+	assert.Equal(t, "3.3.3.3:80", addr.String())
+	addr, err = nx.Resolve("tcp", "other.example:80")
+	require.NoError(t, err)
+	assert.Equal(t, "1.1.1.1:80", addr.String())
+
+	remove()
+	addr, err = nx.Resolve("tcp", "rewritten.example:80")
+	require.NoError(t, err)
+	assert.Equal(t, "1.1.1.1:80", addr.String())
+}
+
+func TestMiddlewareComposedOnce(t *testing.T) {
+	nx := New()
+	nx.OverrideDial(func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return nil, assert.AnError
+	})
+	var wrapped, dialed int
+	nx.UseDialMiddleware(func(next DialFunc) DialFunc {
+		wrapped++
+		return func(ctx context.Context, network, addr string) (net.Conn, error) {
+			dialed++
+			return next(ctx, network, addr)
+		}
+	})
+	for i := 0; i < 3; i++ {
+		_, _ = nx.DialContext(context.Background(), "tcp", "127.0.0.1:1")
+	}
+	assert.Equal(t, 3, dialed)
+	assert.Equal(t, 1, wrapped, "middleware should only wrap once")
+
+	// overriding the dial function wraps it again
+	var overridden int
+	nx.OverrideDial(func(ctx context.Context, network, addr string) (net.Conn, error) {
+		overridden++
+		return nil, assert.AnError
+	})
+	_, _ = nx.DialContext(context.Background(), "tcp", "127.0.0.1:1")
+	assert.Equal(t, 2, wrapped)
+	assert.Equal(t, 1, overridden)
+	assert.Equal(t, 4, dialed)
+}
@@ -0,0 +1,541 @@
+package netx
+
+import (
+	"net"
+	"strings"
+	"time"
+
+	"github.com/getlantern/errors"
+)
+
+var (
+	// nat64WellKnownIPs are the addresses that DefaultNAT64DiscoveryHost
+	// resolves to.
+	nat64WellKnownIPs = []net.IP{
+		net.IPv4(192, 0, 0, 170).To4(),
+		net.IPv4(192, 0, 0, 171).To4(),
+	}
+
+	// nat64PrefixLengths are the prefix lengths allowed by RFC 6052, in the order
+	// in which discovery looks for them. /96 is checked first since it's by far
+	// the most common and because RFC 7050 says to prefer it when the well-known
+	// address could be found at more than one position.
+	nat64PrefixLengths = []int{96, 64, 56, 48, 40, 32}
+)
+
+// NAT64Prefix is a NAT64 prefix of one of the lengths allowed by RFC 6052
+// (32, 40, 48, 56, 64 or 96 bits).
+type NAT64Prefix struct {
+	net.IPNet
+}
+
+// NewNAT64Prefix constructs a NAT64Prefix from the leading bits of ip.
+func NewNAT64Prefix(ip net.IP, bits int) (*NAT64Prefix, bool) {
+	if !validNAT64PrefixLength(bits) {
+		return nil, false
+	}
+	ip16 := ip.To16()
+	if ip16 == nil || ip.To4() != nil {
+		return nil, false
+	}
+	mask := net.CIDRMask(bits, 8*net.IPv6len)
+	return &NAT64Prefix{net.IPNet{IP: ip16.Mask(mask), Mask: mask}}, true
+}
+
+// Bits returns the length of the prefix in bits.
+func (p *NAT64Prefix) Bits() int {
+	ones, _ := p.Mask.Size()
+	return ones
+}
+
+// Synthesize embeds the given IPv4 address in this prefix as described in RFC
+// 6052 section 2.2, skipping the reserved u-octet (bits 64 to 71).
+func (p *NAT64Prefix) Synthesize(ip4 net.IP) net.IP {
+	ip4 = ip4.To4()
+	if ip4 == nil {
+		return nil
+	}
+	ip6 := make(net.IP, net.IPv6len)
+	copy(ip6, p.IP)
+	for i, pos := range ipv4Positions(p.Bits()) {
+		ip6[pos] = ip4[i]
+	}
+	return ip6
+}
+
+// Extract returns the IPv4 address embedded in the given IPv6 address, or nil
+// if the address doesn't belong to this prefix.
+func (p *NAT64Prefix) Extract(ip6 net.IP) net.IP {
+	if ip6.To4() != nil || !p.Contains(ip6) {
+		return nil
+	}
+	return extractIPv4(ip6.To16(), p.Bits())
+}
+
+func (p *NAT64Prefix) equal(other *NAT64Prefix) bool {
+	return p.IP.Equal(other.IP) && p.Bits() == other.Bits()
+}
+
+func validNAT64PrefixLength(bits int) bool {
+	for _, allowed := range nat64PrefixLengths {
+		if bits == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// ipv4Positions returns the positions of the bytes of the IPv4 address within
+// an IPv6 address using a NAT64 prefix of the given length.
+func ipv4Positions(bits int) [4]int {
+	var positions [4]int
+	pos := bits / 8
+	for i := range positions {
+		if pos == 8 {
+			// skip the u-octet
+			pos++
+		}
+		positions[i] = pos
+		pos++
+	}
+	return positions
+}
+
+func extractIPv4(ip6 net.IP, bits int) net.IP {
+	ip4 := make(net.IP, net.IPv4len)
+	for i, pos := range ipv4Positions(bits) {
+		ip4[i] = ip6[pos]
+	}
+	return ip4
+}
+
+// discoverNAT64Prefixes finds the NAT64 prefixes used to synthesize the given
+// AAAA answers for ipv4only.arpa by locating the well-known IPv4 addresses
+// within them (RFC 7050 section 3).
+func discoverNAT64Prefixes(ips []net.IP) []*NAT64Prefix {
+	var prefixes []*NAT64Prefix
+	for _, ip := range ips {
+		if ip.To4() != nil {
+			continue
+		}
+		ip6 := ip.To16()
+		if ip6 == nil {
+			continue
+		}
+		for _, bits := range nat64PrefixLengths {
+			if bits < 96 && ip6[8] != 0 {
+				// u-octet must be zero
+				continue
+			}
+			if !isNAT64WellKnownIP(extractIPv4(ip6, bits)) {
+				continue
+			}
+			prefix, _ := NewNAT64Prefix(ip6, bits)
+			if prefix.IP.Equal(net.IPv6zero) {
+				// an IPv4-compatible address, not a real prefix
+				break
+			}
+			if !containsNAT64Prefix(prefixes, prefix) {
+				prefixes = append(prefixes, prefix)
+			}
+			break
+		}
+	}
+	return prefixes
+}
+
+func isNAT64WellKnownIP(ip net.IP) bool {
+	for _, wka := range nat64WellKnownIPs {
+		if ip.Equal(wka) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsNAT64Prefix(prefixes []*NAT64Prefix, prefix *NAT64Prefix) bool {
+	for _, candidate := range prefixes {
+		if candidate.equal(prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func nat64PrefixesEqual(a, b []*NAT64Prefix) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].equal(b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func formatNAT64Prefixes(prefixes []*NAT64Prefix) string {
+	strs := make([]string, 0, len(prefixes))
+	for _, prefix := range prefixes {
+		strs = append(strs, prefix.String())
+	}
+	return "[" + strings.Join(strs, " ") + "]"
+}
+
+// getNAT64Prefix returns the primary previously discovered NAT64 prefix, or nil
+// if none was discovered.
+func (nx *Netx) getNAT64Prefix() *NAT64Prefix {
+	prefixes := nx.getNAT64Prefixes()
+	if len(prefixes) == 0 {
+		return nil
+	}
+	return prefixes[0]
+}
+
+// getNAT64Prefixes returns the NAT64 prefixes from the highest priority source
+// that has any.
+func (nx *Netx) getNAT64Prefixes() []*NAT64Prefix {
+	prefixes, _ := nx.currentNAT64Prefixes()
+	return prefixes
+}
+
+func (nx *Netx) currentNAT64Prefixes() ([]*NAT64Prefix, NAT64PrefixSource) {
+	nx.nat64PrefixMx.RLock()
+	defer nx.nat64PrefixMx.RUnlock()
+	return nx.currentNAT64PrefixesLocked()
+}
+
+// currentNAT64PrefixesLocked is like currentNAT64Prefixes but must be called
+// with nx.nat64PrefixMx held.
+func (nx *Netx) currentNAT64PrefixesLocked() ([]*NAT64Prefix, NAT64PrefixSource) {
+	if len(nx.nat64StaticPrefixes) > 0 {
+		return nx.nat64StaticPrefixes, NAT64SourceStatic
+	}
+	now := time.Now()
+	var raPrefixes []*NAT64Prefix
+	for _, ra := range nx.nat64RAPrefixes {
+		if now.Before(ra.expires) {
+			raPrefixes = append(raPrefixes, ra.prefix)
+		}
+	}
+	if len(raPrefixes) > 0 {
+		return raPrefixes, NAT64SourceRouterAdvertisement
+	}
+	if len(nx.nat64DNSPrefixes) > 0 {
+		return nx.nat64DNSPrefixes, NAT64SourceDNS
+	}
+	return nil, NAT64SourceNone
+}
+
+// NAT64PrefixSource identifies where a NAT64 prefix came from.
+type NAT64PrefixSource int
+
+// NAT64 prefix sources, in order of decreasing priority. Prefixes are only
+// taken from a source if no higher priority source has any.
+const (
+	// NAT64SourceStatic is a prefix configured with SetNAT64Prefix.
+	NAT64SourceStatic NAT64PrefixSource = iota
+	// NAT64SourceRouterAdvertisement is a prefix learned from the PREF64 option
+	// of a router advertisement (RFC 8781).
+	NAT64SourceRouterAdvertisement
+	// NAT64SourceDNS is a prefix discovered by querying ipv4only.arpa (RFC 7050).
+	NAT64SourceDNS
+	// NAT64SourceNone means that no prefix is known.
+	NAT64SourceNone
+)
+
+func (s NAT64PrefixSource) String() string {
+	switch s {
+	case NAT64SourceStatic:
+		return "static"
+	case NAT64SourceRouterAdvertisement:
+		return "router advertisement"
+	case NAT64SourceDNS:
+		return "dns"
+	default:
+		return "none"
+	}
+}
+
+// ParseNAT64Prefix parses a NAT64 prefix in CIDR notation, like
+// "64:ff9b::/96".
+func ParseNAT64Prefix(s string) (*NAT64Prefix, error) {
+	ip, ipNet, err := net.ParseCIDR(s)
+	if err != nil {
+		return nil, errors.New("Unable to parse NAT64 prefix %v: %v", s, err)
+	}
+	bits, _ := ipNet.Mask.Size()
+	prefix, ok := NewNAT64Prefix(ip, bits)
+	if !ok {
+		return nil, errors.New("Invalid NAT64 prefix %v", s)
+	}
+	return prefix, nil
+}
+
+// SetNAT64Prefix statically configures the global NAT64 prefixes. See
+// Netx.SetNAT64Prefix.
+func SetNAT64Prefix(prefixes ...*NAT64Prefix) {
+	defaultNetx.SetNAT64Prefix(prefixes...)
+}
+
+// SetNAT64Prefix statically configures the NAT64 prefixes to use, taking
+// priority over prefixes learned from router advertisements or DNS. Calling it
+// with no prefixes removes the static configuration. Nil prefixes are ignored.
+func (nx *Netx) SetNAT64Prefix(prefixes ...*NAT64Prefix) {
+	var static []*NAT64Prefix
+	for _, prefix := range prefixes {
+		if prefix != nil {
+			static = append(static, prefix)
+		}
+	}
+	nx.nat64PrefixMx.Lock()
+	nx.nat64StaticPrefixes = static
+	nx.nat64PrefixMx.Unlock()
+	nx.notifyNAT64Change()
+}
+
+// raNAT64Prefix is a NAT64 prefix learned from a router advertisement along
+// with when it expires.
+type raNAT64Prefix struct {
+	prefix  *NAT64Prefix
+	expires time.Time
+}
+
+// HandleRouterAdvertisement records the NAT64 prefixes advertised in the global
+// Netx. See Netx.HandleRouterAdvertisement.
+func HandleRouterAdvertisement(msg []byte) error {
+	return defaultNetx.HandleRouterAdvertisement(msg)
+}
+
+// HandleRouterAdvertisement parses the raw ICMPv6 router advertisement in msg
+// and records any NAT64 prefixes advertised in PREF64 options until their
+// lifetime runs out. Prefixes advertised with a lifetime of 0 are removed.
+func (nx *Netx) HandleRouterAdvertisement(msg []byte) error {
+	pref64s, err := ParseRouterAdvertisement(msg)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	nx.nat64PrefixMx.Lock()
+	for _, pref64 := range pref64s {
+		if pref64.Prefix == nil {
+			continue
+		}
+		updated := make([]raNAT64Prefix, 0, len(nx.nat64RAPrefixes)+1)
+		for _, existing := range nx.nat64RAPrefixes {
+			if !existing.prefix.equal(pref64.Prefix) && now.Before(existing.expires) {
+				updated = append(updated, existing)
+			}
+		}
+		if pref64.Lifetime > 0 {
+			updated = append(updated, raNAT64Prefix{prefix: pref64.Prefix, expires: now.Add(pref64.Lifetime)})
+		}
+		nx.nat64RAPrefixes = updated
+	}
+	nx.scheduleNAT64RAExpiryLocked(now)
+	nx.nat64PrefixMx.Unlock()
+	nx.notifyNAT64Change()
+	return nil
+}
+
+// scheduleNAT64RAExpiryLocked arranges for expireNAT64RAPrefixes to run when
+// the first of the prefixes learned from router advertisements expires, so
+// that subscribers hear about it. It must be called with nx.nat64PrefixMx
+// held.
+func (nx *Netx) scheduleNAT64RAExpiryLocked(now time.Time) {
+	if nx.nat64RAExpiry != nil {
+		nx.nat64RAExpiry.Stop()
+		nx.nat64RAExpiry = nil
+	}
+	var earliest time.Time
+	for _, ra := range nx.nat64RAPrefixes {
+		if now.Before(ra.expires) && (earliest.IsZero() || ra.expires.Before(earliest)) {
+			earliest = ra.expires
+		}
+	}
+	if !earliest.IsZero() {
+		nx.nat64RAExpiry = time.AfterFunc(earliest.Sub(now), nx.expireNAT64RAPrefixes)
+	}
+}
+
+// expireNAT64RAPrefixes forgets the prefixes learned from router
+// advertisements whose lifetime has run out and notifies subscribers if that
+// changed the prefix used for dialing.
+func (nx *Netx) expireNAT64RAPrefixes() {
+	now := time.Now()
+	nx.nat64PrefixMx.Lock()
+	var current []raNAT64Prefix
+	for _, ra := range nx.nat64RAPrefixes {
+		if now.Before(ra.expires) {
+			current = append(current, ra)
+		}
+	}
+	nx.nat64RAPrefixes = current
+	nx.scheduleNAT64RAExpiryLocked(now)
+	nx.nat64PrefixMx.Unlock()
+	nx.notifyNAT64Change()
+}
+
+// NAT64State describes the current NAT64 configuration of a Netx.
+type NAT64State struct {
+	// Prefix is the prefix used when dialing, or nil if we're not on a NAT64
+	// network.
+	Prefix *NAT64Prefix
+	// Prefixes are all known prefixes from the same source as Prefix.
+	Prefixes []*NAT64Prefix
+	// Source is where the prefixes came from.
+	Source NAT64PrefixSource
+	// LastChecked is when the DNS based discovery last ran. It's zero if
+	// discovery hasn't run yet.
+	LastChecked time.Time
+	// LastError is the error from the last DNS based discovery, if any.
+	LastError error
+}
+
+// NAT64Status returns the current global NAT64 state.
+func NAT64Status() NAT64State {
+	return defaultNetx.NAT64Status()
+}
+
+// NAT64Status returns the current NAT64 state of this Netx.
+func (nx *Netx) NAT64Status() NAT64State {
+	nx.nat64PrefixMx.RLock()
+	defer nx.nat64PrefixMx.RUnlock()
+	prefixes, source := nx.currentNAT64PrefixesLocked()
+	status := NAT64State{
+		Prefixes:    prefixes,
+		Source:      source,
+		LastChecked: nx.nat64LastChecked,
+		LastError:   nx.nat64LastErr,
+	}
+	if len(prefixes) > 0 {
+		status.Prefix = prefixes[0]
+	}
+	return status
+}
+
+// SubscribeNAT64 subscribes to changes of the global NAT64 prefix. See
+// Netx.SubscribeNAT64.
+func SubscribeNAT64(cb func(prefix *NAT64Prefix)) (unsubscribe func()) {
+	return defaultNetx.SubscribeNAT64(cb)
+}
+
+// SubscribeNAT64 registers a callback that is called whenever the NAT64 prefix
+// used for dialing changes, including when it goes away, in which case prefix
+// is nil. Callbacks are called one at a time and in the order the changes
+// happened, from whatever updated the prefix, which may be the discovery
+// goroutine, so they shouldn't block for long. In particular a callback mustn't
+// call NAT64Discovery.Stop or Reset, which wait for the discovery goroutine and
+// would therefore deadlock. Call the returned function to unsubscribe.
+func (nx *Netx) SubscribeNAT64(cb func(prefix *NAT64Prefix)) (unsubscribe func()) {
+	nx.nat64PrefixMx.Lock()
+	defer nx.nat64PrefixMx.Unlock()
+	if nx.nat64Subscribers == nil {
+		nx.nat64Subscribers = make(map[int]func(*NAT64Prefix))
+	}
+	id := nx.nat64NextSubscriber
+	nx.nat64NextSubscriber++
+	nx.nat64Subscribers[id] = cb
+	return func() {
+		nx.nat64PrefixMx.Lock()
+		delete(nx.nat64Subscribers, id)
+		nx.nat64PrefixMx.Unlock()
+	}
+}
+
+// nat64Notification is a change of the NAT64 prefix waiting to be delivered to
+// subscribers.
+type nat64Notification struct {
+	prefix      *NAT64Prefix
+	subscribers []func(*NAT64Prefix)
+}
+
+// notifyNAT64Change calls the subscribers if the NAT64 prefix used for dialing
+// has changed since they were last called. Notifications are queued and
+// delivered in order by whichever goroutine is already delivering them, so
+// that concurrent changes can't reach subscribers out of order and
+// subscribers can themselves change the prefix.
+func (nx *Netx) notifyNAT64Change() {
+	nx.nat64PrefixMx.Lock()
+	prefixes, _ := nx.currentNAT64PrefixesLocked()
+	var prefix *NAT64Prefix
+	if len(prefixes) > 0 {
+		prefix = prefixes[0]
+	}
+	if sameNAT64Prefix(prefix, nx.nat64Notified) {
+		nx.nat64PrefixMx.Unlock()
+		return
+	}
+	nx.nat64Notified = prefix
+	subscribers := make([]func(*NAT64Prefix), 0, len(nx.nat64Subscribers))
+	for _, subscriber := range nx.nat64Subscribers {
+		subscribers = append(subscribers, subscriber)
+	}
+	nx.nat64PendingNotifications = append(nx.nat64PendingNotifications, nat64Notification{prefix, subscribers})
+	if nx.nat64Notifying {
+		nx.nat64PrefixMx.Unlock()
+		return
+	}
+	nx.nat64Notifying = true
+	nx.nat64PrefixMx.Unlock()
+	nx.deliverNAT64Notifications()
+}
+
+// deliverNAT64Notifications calls the subscribers for each pending
+// notification until there are none left. If a subscriber panics, the
+// remaining notifications are left for whoever changes the prefix next.
+func (nx *Netx) deliverNAT64Notifications() {
+	finished := false
+	defer func() {
+		if !finished {
+			nx.nat64PrefixMx.Lock()
+			nx.nat64Notifying = false
+			nx.nat64PrefixMx.Unlock()
+		}
+	}()
+	for {
+		nx.nat64PrefixMx.Lock()
+		if len(nx.nat64PendingNotifications) == 0 {
+			nx.nat64PendingNotifications = nil
+			nx.nat64Notifying = false
+			nx.nat64PrefixMx.Unlock()
+			finished = true
+			return
+		}
+		notification := nx.nat64PendingNotifications[0]
+		nx.nat64PendingNotifications = nx.nat64PendingNotifications[1:]
+		nx.nat64PrefixMx.Unlock()
+		for _, subscriber := range notification.subscribers {
+			subscriber(notification.prefix)
+		}
+	}
+}
+
+func sameNAT64Prefix(a, b *NAT64Prefix) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.equal(b)
+}
+
+// convertAddressDNS64 takes the IP address, converts it to ipv6 and applies DNS64 prefix
+func convertAddressDNS64(prefix *NAT64Prefix, addr string) string {
+	if prefix == nil {
+		return addr
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	ip := net.ParseIP(host)
+	if ip.To4() == nil { // if it's ipv6 already - don't do anything
+		return addr
+	}
+	if ipt.IsPrivate(&net.IPAddr{
+		IP: ip,
+	}) {
+		// don't mess with private IP addresses
+		return addr
+	}
+	return net.JoinHostPort(prefix.Synthesize(ip).String(), port)
+}
@@ -0,0 +1,221 @@
+package netx
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// These examples are taken from RFC 6052 section 2.4.
+var rfc6052Examples = []struct {
+	prefix      string
+	bits        int
+	synthesized string
+}{
+	{"2001:db8::", 32, "2001:db8:c000:221::"},
+	{"2001:db8:100::", 40, "2001:db8:1c0:2:21::"},
+	{"2001:db8:122::", 48, "2001:db8:122:c000:2:2100::"},
+	{"2001:db8:122:300::", 56, "2001:db8:122:3c0:0:221::"},
+	{"2001:db8:122:344::", 64, "2001:db8:122:344:c0:2:2100:0"},
+	{"2001:db8:122:344::", 96, "2001:db8:122:344::192.0.2.33"},
+	{"64:ff9b::", 96, "64:ff9b::192.0.2.33"},
+}
+
+func TestNAT64PrefixSynthesizeAndExtract(t *testing.T) {
+	ip4 := net.ParseIP("192.0.2.33")
+	for _, example := range rfc6052Examples {
+		prefix, ok := NewNAT64Prefix(net.ParseIP(example.prefix), example.bits)
+		require.True(t, ok)
+		synthesized := prefix.Synthesize(ip4)
+		assert.Equal(t, net.ParseIP(example.synthesized).String(), synthesized.String(), "/%d", example.bits)
+		assert.Equal(t, ip4.To4(), prefix.Extract(synthesized), "/%d", example.bits)
+	}
+
+	_, ok := NewNAT64Prefix(net.ParseIP("64:ff9b::"), 80)
+	assert.False(t, ok, "80 is not a valid prefix length")
+}
+
+func TestDiscoverNAT64Prefixes(t *testing.T) {
+	for _, example := range rfc6052Examples {
+		prefix, _ := NewNAT64Prefix(net.ParseIP(example.prefix), example.bits)
+		ips := []net.IP{
+			net.ParseIP("192.0.0.170"),
+			prefix.Synthesize(net.ParseIP("192.0.0.170")),
+			prefix.Synthesize(net.ParseIP("192.0.0.171")),
+		}
+		discovered := discoverNAT64Prefixes(ips)
+		if assert.Len(t, discovered, 1, "/%d", example.bits) {
+			assert.Equal(t, prefix.String(), discovered[0].String())
+		}
+	}
+
+	a, _ := NewNAT64Prefix(net.ParseIP("64:ff9b::"), 96)
+	b, _ := NewNAT64Prefix(net.ParseIP("2001:db8:122:344::"), 64)
+	discovered := discoverNAT64Prefixes([]net.IP{
+		a.Synthesize(net.ParseIP("192.0.0.170")),
+		b.Synthesize(net.ParseIP("192.0.0.171")),
+		net.ParseIP("2001:db8::1"),
+	})
+	assert.Equal(t, "[64:ff9b::/96 2001:db8:122:344::/64]", formatNAT64Prefixes(discovered))
+
+	assert.Empty(t, discoverNAT64Prefixes([]net.IP{net.ParseIP("::192.0.0.170")}), "IPv4-compatible address should not yield a prefix")
+}
+
+func TestConvertAddressDNS64(t *testing.T) {
+	prefix, _ := NewNAT64Prefix(net.ParseIP("2001:db8:122:344::"), 64)
+	assert.Equal(t, "[2001:db8:122:344:5d:b8d8:2200:0]:443", convertAddressDNS64(prefix, "93.184.216.34:443"))
+	assert.Equal(t, "10.0.0.1:443", convertAddressDNS64(prefix, "10.0.0.1:443"), "private addresses should not be converted")
+	assert.Equal(t, "[2001:db8::1]:443", convertAddressDNS64(prefix, "[2001:db8::1]:443"))
+	assert.Equal(t, "93.184.216.34:443", convertAddressDNS64(nil, "93.184.216.34:443"))
+}
+
+func TestNAT64StatusAndSubscribe(t *testing.T) {
+	var ipv4onlyIPs []net.IP
+	var lookupErr error
+	nx := New()
+	nx.OverrideResolveIPs(func(host string) ([]net.IP, error) {
+		return ipv4onlyIPs, lookupErr
+	})
+
+	var notified []string
+	unsubscribe := nx.SubscribeNAT64(func(prefix *NAT64Prefix) {
+		if prefix == nil {
+			notified = append(notified, "none")
+		} else {
+			notified = append(notified, prefix.String())
+		}
+	})
+
+	status := nx.NAT64Status()
+	assert.Nil(t, status.Prefix)
+	assert.True(t, status.LastChecked.IsZero())
+
+	ipv4onlyIPs = []net.IP{net.ParseIP("64:ff9b::c000:aa")}
+	before := time.Now()
+	nx.updateNAT64Prefix(context.Background(), DefaultNAT64DiscoveryHost)
+	status = nx.NAT64Status()
+	require.NotNil(t, status.Prefix)
+	assert.Equal(t, "64:ff9b::/96", status.Prefix.String())
+	assert.Equal(t, NAT64SourceDNS, status.Source)
+	assert.False(t, status.LastChecked.Before(before))
+	assert.NoError(t, status.LastError)
+
+	// an unchanged prefix shouldn't notify again
+	nx.updateNAT64Prefix(context.Background(), DefaultNAT64DiscoveryHost)
+
+	// a failed check keeps the prior prefix but records the error
+	lookupErr = assert.AnError
+	nx.updateNAT64Prefix(context.Background(), DefaultNAT64DiscoveryHost)
+	status = nx.NAT64Status()
+	assert.Equal(t, assert.AnError, status.LastError)
+	assert.Equal(t, "64:ff9b::/96", status.Prefix.String())
+
+	lookupErr = nil
+	ipv4onlyIPs = []net.IP{net.ParseIP("192.0.0.170")}
+	nx.updateNAT64Prefix(context.Background(), DefaultNAT64DiscoveryHost)
+	assert.Nil(t, nx.NAT64Status().Prefix)
+
+	static, _ := ParseNAT64Prefix("2001:db8::/32")
+	nx.SetNAT64Prefix(static)
+	unsubscribe()
+	nx.SetNAT64Prefix()
+
+	assert.Equal(t, []string{"64:ff9b::/96", "none", "2001:db8::/32"}, notified)
+}
+
+func TestNAT64NotificationsInOrder(t *testing.T) {
+	nx := New()
+	a, _ := ParseNAT64Prefix("64:ff9b::/96")
+	b, _ := ParseNAT64Prefix("2001:db8::/32")
+	var notified []*NAT64Prefix
+	nx.SubscribeNAT64(func(prefix *NAT64Prefix) {
+		notified = append(notified, prefix)
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if i%2 == 0 {
+				nx.SetNAT64Prefix(a)
+			} else {
+				nx.SetNAT64Prefix(b)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	require.NotEmpty(t, notified)
+	assert.Equal(t, nx.NAT64Status().Prefix, notified[len(notified)-1], "subscribers should end up with the current prefix")
+	for i := 1; i < len(notified); i++ {
+		assert.NotEqual(t, notified[i-1].String(), notified[i].String(), "each notification should be a change")
+	}
+}
+
+func TestNAT64SubscriberCanChangePrefix(t *testing.T) {
+	nx := New()
+	a, _ := ParseNAT64Prefix("64:ff9b::/96")
+	b, _ := ParseNAT64Prefix("2001:db8::/32")
+	var notified []string
+	nx.SubscribeNAT64(func(prefix *NAT64Prefix) {
+		notified = append(notified, prefix.String())
+		if prefix.equal(a) {
+			nx.SetNAT64Prefix(b)
+		}
+	})
+	nx.SetNAT64Prefix(a)
+	assert.Equal(t, []string{"64:ff9b::/96", "2001:db8::/32"}, notified)
+}
+
+func TestNAT64SubscriberPanic(t *testing.T) {
+	nx := New()
+	a, _ := ParseNAT64Prefix("64:ff9b::/96")
+	b, _ := ParseNAT64Prefix("2001:db8::/32")
+	panicking := true
+	var notified []string
+	nx.SubscribeNAT64(func(prefix *NAT64Prefix) {
+		if panicking {
+			panic("boom")
+		}
+		notified = append(notified, prefix.String())
+	})
+	assert.Panics(t, func() { nx.SetNAT64Prefix(a) })
+
+	// a panicking subscriber mustn't stop later notifications
+	panicking = false
+	nx.SetNAT64Prefix(b)
+	assert.Equal(t, []string{"2001:db8::/32"}, notified)
+}
+
+func TestNAT64RAPrefixExpiryNotifies(t *testing.T) {
+	nx := New()
+	ra, _ := ParseNAT64Prefix("2001:db8::/32")
+	dns, _ := ParseNAT64Prefix("64:ff9b::/96")
+	notified := make(chan *NAT64Prefix, 10)
+	nx.SubscribeNAT64(func(prefix *NAT64Prefix) {
+		notified <- prefix
+	})
+
+	now := time.Now()
+	nx.nat64PrefixMx.Lock()
+	nx.nat64DNSPrefixes = []*NAT64Prefix{dns}
+	nx.nat64RAPrefixes = []raNAT64Prefix{{prefix: ra, expires: now.Add(50 * time.Millisecond)}}
+	nx.scheduleNAT64RAExpiryLocked(now)
+	nx.nat64PrefixMx.Unlock()
+	nx.notifyNAT64Change()
+	assert.Equal(t, ra, <-notified)
+
+	select {
+	case prefix := <-notified:
+		assert.Equal(t, dns, prefix, "should fall back to the DNS prefix once the RA prefix expires")
+	case <-time.After(time.Second):
+		t.Fatal("expiry of the RA prefix should notify subscribers")
+	}
+	assert.Equal(t, NAT64SourceDNS, nx.NAT64Status().Source)
+}
@@ -0,0 +1,212 @@
+package netx
+
+import (
+	"context"
+	"time"
+)
+
+const (
+	// DefaultNAT64DiscoveryHost is the well-known IPv4-only name used to discover
+	// NAT64 prefixes (RFC 7050).
+	DefaultNAT64DiscoveryHost = "ipv4only.arpa"
+
+	// DefaultNAT64QueryInterval is the default minimum time between NAT64 prefix
+	// discovery queries.
+	DefaultNAT64QueryInterval = 10 * time.Second
+)
+
+// NAT64DiscoveryOpts provides options for StartNAT64Discovery. It will use
+// sensible defaults for any missing options.
+type NAT64DiscoveryOpts struct {
+	// QueryInterval is the minimum time between two discovery queries, except
+	// for ones requested with RefreshNow.
+	QueryInterval time.Duration
+	// Host is the name to query for, which must only have A records.
+	Host string
+}
+
+func (opts *NAT64DiscoveryOpts) ApplyDefaults() {
+	if opts.QueryInterval <= 0 {
+		opts.QueryInterval = DefaultNAT64QueryInterval
+	}
+	if opts.Host == "" {
+		opts.Host = DefaultNAT64DiscoveryHost
+	}
+}
+
+// NAT64Discovery is a running NAT64 prefix discovery started by
+// StartNAT64Discovery.
+type NAT64Discovery struct {
+	nx        *Netx
+	opts      NAT64DiscoveryOpts
+	refreshCh chan struct{}
+	cancel    context.CancelFunc
+	done      chan struct{}
+}
+
+// EnableNAT64 enables automatic discovery of NAT64 prefix using DNS query for ipv4only.arpa.
+// Once enabled, netx will automatically dial IPv4 addresses via IPv6 using this prefix
+// if it is available
+func EnableNAT64AutoDiscovery() {
+	defaultNetx.EnableNAT64AutoDiscovery()
+}
+
+// EnableNAT64AutoDiscovery is like the package-level EnableNAT64AutoDiscovery
+// but only affects this Netx. It does nothing if discovery is already running.
+func (nx *Netx) EnableNAT64AutoDiscovery() {
+	nx.nat64DiscoveryMx.Lock()
+	defer nx.nat64DiscoveryMx.Unlock()
+	if nx.nat64Discovery == nil {
+		nx.startNAT64DiscoveryLocked(context.Background(), nil)
+	}
+}
+
+// StartNAT64Discovery starts discovering the global NAT64 prefix. See
+// Netx.StartNAT64Discovery.
+func StartNAT64Discovery(ctx context.Context, opts *NAT64DiscoveryOpts) *NAT64Discovery {
+	return defaultNetx.StartNAT64Discovery(ctx, opts)
+}
+
+// StartNAT64Discovery starts discovering the NAT64 prefix by querying for
+// opts.Host. It queries once right away and then again whenever dialing fails
+// or RefreshNow is called, but no more often than opts.QueryInterval.
+// Discovery runs until ctx is done or Stop is called. Starting discovery stops
+// any discovery that was previously started on this Netx, as does Reset.
+func (nx *Netx) StartNAT64Discovery(ctx context.Context, opts *NAT64DiscoveryOpts) *NAT64Discovery {
+	nx.nat64DiscoveryMx.Lock()
+	prior := nx.nat64Discovery
+	d := nx.startNAT64DiscoveryLocked(ctx, opts)
+	nx.nat64DiscoveryMx.Unlock()
+	if prior != nil {
+		prior.Stop()
+	}
+	return d
+}
+
+// startNAT64DiscoveryLocked starts discovery and records it as the current
+// one. It must be called with nx.nat64DiscoveryMx held.
+func (nx *Netx) startNAT64DiscoveryLocked(ctx context.Context, opts *NAT64DiscoveryOpts) *NAT64Discovery {
+	if opts == nil {
+		opts = &NAT64DiscoveryOpts{}
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	d := &NAT64Discovery{
+		nx:        nx,
+		opts:      *opts,
+		refreshCh: make(chan struct{}, 1),
+		cancel:    cancel,
+		done:      make(chan struct{}),
+	}
+	d.opts.ApplyDefaults()
+	nx.nat64Discovery = d
+
+	log.Debug("Enabling NAT64 auto-discovery")
+	go d.run(ctx)
+	return d
+}
+
+func (d *NAT64Discovery) run(ctx context.Context) {
+	defer func() {
+		d.nx.nat64DiscoveryMx.Lock()
+		if d.nx.nat64Discovery == d {
+			d.nx.nat64Discovery = nil
+		}
+		d.nx.nat64DiscoveryMx.Unlock()
+		close(d.done)
+	}()
+	for {
+		log.Debugf("Checking for updated NAT64 prefix")
+		d.nx.updateNAT64Prefix(ctx, d.opts.Host)
+
+		// Don't update NAT64 prefix too often
+		timer := time.NewTimer(d.opts.QueryInterval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-d.refreshCh:
+			timer.Stop()
+			continue
+		case <-timer.C:
+		}
+
+		// Only update NAT64 Prefix again if it's necessary
+		select {
+		case <-ctx.Done():
+			return
+		case <-d.refreshCh:
+		case <-d.nx.updateNAT64PrefixCh:
+		}
+	}
+}
+
+// RefreshNow asks for the NAT64 prefix to be discovered again right away,
+// regardless of the query interval.
+func (d *NAT64Discovery) RefreshNow() {
+	select {
+	case d.refreshCh <- struct{}{}:
+	default:
+		// refresh already pending
+	}
+}
+
+// Stop stops discovery and waits for it to finish. The last discovered prefix
+// remains in effect.
+func (d *NAT64Discovery) Stop() {
+	d.cancel()
+	<-d.done
+}
+
+func (nx *Netx) updateNAT64Prefix(ctx context.Context, host string) {
+	ips, err := nx.getResolver().LookupIP(ctx, "ip", host)
+	if ctx.Err() != nil {
+		// stopped while looking up
+		return
+	}
+	nx.nat64PrefixMx.Lock()
+	nx.nat64LastChecked = time.Now()
+	nx.nat64LastErr = err
+	prior := nx.nat64DNSPrefixes
+	if err == nil {
+		nx.nat64DNSPrefixes = discoverNAT64Prefixes(ips)
+	}
+	next := nx.nat64DNSPrefixes
+	nx.nat64PrefixMx.Unlock()
+	if err != nil {
+		_ = log.Errorf("Error checking for updated nat64 prefix: %v", err)
+		return
+	}
+	if !nat64PrefixesEqual(prior, next) {
+		log.Debugf("NAT64 prefix changed from %v to %v", formatNAT64Prefixes(prior), formatNAT64Prefixes(next))
+	}
+	nx.notifyNAT64Change()
+}
+
+func (nx *Netx) refreshNAT64Prefix() {
+	select {
+	case nx.updateNAT64PrefixCh <- nil:
+		// requested refresh of NAT64 prefx
+	default:
+		// refresh already pending
+	}
+}
+
+// resetNAT64 stops any running discovery and forgets all NAT64 prefixes.
+func (nx *Netx) resetNAT64() {
+	nx.nat64DiscoveryMx.Lock()
+	d := nx.nat64Discovery
+	nx.nat64DiscoveryMx.Unlock()
+	if d != nil {
+		d.Stop()
+	}
+
+	nx.nat64PrefixMx.Lock()
+	nx.nat64StaticPrefixes = nil
+	nx.nat64RAPrefixes = nil
+	nx.scheduleNAT64RAExpiryLocked(time.Now())
+	nx.nat64DNSPrefixes = nil
+	nx.nat64LastChecked = time.Time{}
+	nx.nat64LastErr = nil
+	nx.nat64PrefixMx.Unlock()
+	nx.notifyNAT64Change()
+}
@@ -0,0 +1,118 @@
+package netx
+
+import (
+	"context"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/goleak"
+)
+
+type nat64TestResolver struct {
+	queries int32
+	hosts   chan string
+}
+
+func (r *nat64TestResolver) LookupIP(ctx context.Context, network, host string) ([]net.IP, error) {
+	atomic.AddInt32(&r.queries, 1)
+	select {
+	case r.hosts <- host:
+	default:
+	}
+	return []net.IP{net.ParseIP("64:ff9b::c000:aa")}, nil
+}
+
+func (r *nat64TestResolver) count() int {
+	return int(atomic.LoadInt32(&r.queries))
+}
+
+func TestNAT64DiscoveryLifecycle(t *testing.T) {
+	defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
+
+	r := &nat64TestResolver{hosts: make(chan string, 10)}
+	nx := New()
+	nx.OverrideResolver(r)
+
+	d := nx.StartNAT64Discovery(context.Background(), &NAT64DiscoveryOpts{
+		QueryInterval: time.Hour,
+		Host:          "ipv4only.example.com",
+	})
+	select {
+	case host := <-r.hosts:
+		assert.Equal(t, "ipv4only.example.com", host)
+	case <-time.After(5 * time.Second):
+		t.Fatal("discovery should have queried right away")
+	}
+	require.Eventually(t, func() bool { return nx.NAT64Status().Prefix != nil }, 5*time.Second, 5*time.Millisecond)
+
+	// failed dials shouldn't trigger a query within the query interval
+	nx.refreshNAT64Prefix()
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, 1, r.count())
+
+	// but RefreshNow should
+	d.RefreshNow()
+	select {
+	case <-r.hosts:
+	case <-time.After(5 * time.Second):
+		t.Fatal("RefreshNow should have triggered a query")
+	}
+
+	d.Stop()
+	d.Stop()
+	assert.Equal(t, "64:ff9b::/96", nx.NAT64Status().Prefix.String(), "prefix should survive stopping discovery")
+
+	// discovery can be enabled again after Reset
+	nx.Reset()
+	assert.Nil(t, nx.NAT64Status().Prefix)
+	nx.OverrideResolver(r)
+	nx.EnableNAT64AutoDiscovery()
+	require.Eventually(t, func() bool { return nx.NAT64Status().Prefix != nil }, 5*time.Second, 5*time.Millisecond)
+	nx.Reset()
+}
+
+func TestNAT64DiscoveryStopsWithContext(t *testing.T) {
+	defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
+
+	nx := New()
+	nx.OverrideResolver(&nat64TestResolver{hosts: make(chan string, 10)})
+	ctx, cancel := context.WithCancel(context.Background())
+	d := nx.StartNAT64Discovery(ctx, &NAT64DiscoveryOpts{QueryInterval: time.Millisecond})
+	cancel()
+	d.Stop()
+
+	// a new discovery replaces the old one
+	first := nx.StartNAT64Discovery(context.Background(), nil)
+	second := nx.StartNAT64Discovery(context.Background(), nil)
+	first.Stop()
+	nx.nat64DiscoveryMx.Lock()
+	assert.Equal(t, second, nx.nat64Discovery)
+	nx.nat64DiscoveryMx.Unlock()
+	second.Stop()
+}
+
+func TestEnableNAT64AutoDiscoveryConcurrently(t *testing.T) {
+	defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
+
+	r := &nat64TestResolver{hosts: make(chan string, 10)}
+	nx := New()
+	nx.OverrideResolver(r)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			nx.EnableNAT64AutoDiscovery()
+		}()
+	}
+	wg.Wait()
+	require.Eventually(t, func() bool { return nx.NAT64Status().Prefix != nil }, 5*time.Second, 5*time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, 1, r.count(), "discovery should only have been started once")
+	nx.Reset()
+}
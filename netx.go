@@ -3,7 +3,6 @@
 package netx
 
 import (
-	"bytes"
 	"context"
 	"math/rand"
 	"net"
@@ -22,128 +21,94 @@ var (
 )
 
 var (
-	dial                  atomic.Value
-	dialUDP               atomic.Value
-	listenUDP             atomic.Value
-	resolveIPs            atomic.Value
-	enableNAT64Once       sync.Once
-	nat64Prefix           []byte
-	nat64PrefixMx         sync.RWMutex
-	updateNAT64PrefixCh   = make(chan interface{}, 1)
-	defaultDialTimeout    = 1 * time.Minute
-	minNAT64QueryInterval = 10 * time.Second
-	zero                  = []byte{0}
-	ipt                   iptool.Tool
+	defaultNetx        *Netx
+	defaultDialTimeout = 1 * time.Minute
+	ipt                iptool.Tool
 )
 
 func init() {
 	ipt, _ = iptool.New()
-	Reset()
-}
-
-// EnableNAT64 enables automatic discovery of NAT64 prefix using DNS query for ipv4only.arpa.
-// Once enabled, netx will automatically dial IPv4 addresses via IPv6 using this prefix
-// if it is available
-func EnableNAT64AutoDiscovery() {
-	enableNAT64Once.Do(func() {
-		log.Debug("Enabling NAT64 auto-discovery")
-		go func() {
-			var priorNAT64Prefix []byte
-			for {
-				log.Debugf("Checking for updated NAT64 prefix")
-				updateNAT64Prefix()
-				nextNAT64Prefix := getNAT64Prefix()
-				if !bytes.Equal(priorNAT64Prefix, nextNAT64Prefix) {
-					log.Debugf("NAT64 prefix changed from %v to %v", priorNAT64Prefix, nextNAT64Prefix)
-					priorNAT64Prefix = nextNAT64Prefix
-				}
-				// Don't updat NAT64 prefix too often
-				time.Sleep(minNAT64QueryInterval)
-				// Only update NAT64 Prefix again if it's necessary
-				<-updateNAT64PrefixCh
-			}
-		}()
-	})
+	defaultNetx = New()
 }
 
-func updateNAT64Prefix() {
-	ips, err := resolveIPs.Load().(func(string) ([]net.IP, error))("ipv4only.arpa")
-	if err != nil {
-		_ = log.Errorf("Error checking for updated nat64 prefix: %v", err)
-		return
-	}
-	for _, ip := range ips {
-		if ip.To4() == nil {
-			prefix := ip[:12]
-			if bytes.Count(prefix, zero) < 12 {
-				nat64PrefixMx.Lock()
-				nat64Prefix = prefix
-				nat64PrefixMx.Unlock()
-				return
-			}
-		}
-	}
-
-	nat64PrefixMx.Lock()
-	nat64Prefix = nil
-	nat64PrefixMx.Unlock()
+// Netx carries its own dial, listen and resolve functions along with its own
+// NAT64 state, so that different components in the same process can use
+// different settings. The package-level functions all delegate to a default
+// Netx.
+type Netx struct {
+	// happyEyeballsDelay is accessed atomically and is kept first so that it's
+	// 64-bit aligned on 32-bit platforms.
+	happyEyeballsDelay int64
+	familyPreference   int32
+	hostsOverride      atomic.Value
+	retryPolicy        atomic.Value
+	circuitBreaker     atomic.Value
+	ipSelector         atomic.Value
+	// the middleware chains also hold the overridden functions they wrap
+	dialMiddleware      middlewareChain[DialFunc]
+	dialUDPMiddleware   middlewareChain[DialUDPFunc]
+	listenMiddleware    middlewareChain[ListenPacketFunc]
+	resolverMiddleware  middlewareChain[Resolver]
+	nat64StaticPrefixes []*NAT64Prefix
+	nat64RAPrefixes     []raNAT64Prefix
+	nat64RAExpiry       *time.Timer
+	nat64DNSPrefixes    []*NAT64Prefix
+	nat64LastChecked    time.Time
+	nat64LastErr        error
+	nat64Notified       *NAT64Prefix
+	nat64Subscribers    map[int]func(*NAT64Prefix)
+	nat64NextSubscriber int
+	// nat64PendingNotifications and nat64Notifying are used by
+	// notifyNAT64Change to deliver notifications in order.
+	nat64PendingNotifications []nat64Notification
+	nat64Notifying            bool
+	nat64PrefixMx             sync.RWMutex
+	updateNAT64PrefixCh       chan interface{}
+	nat64Discovery            *NAT64Discovery
+	nat64DiscoveryMx          sync.Mutex
 }
 
-func refreshNAT64Prefix() {
-	select {
-	case updateNAT64PrefixCh <- nil:
-		// requested refresh of NAT64 prefx
-	default:
-		// refresh already pending
+// New constructs a new Netx with default settings.
+func New() *Netx {
+	nx := &Netx{
+		updateNAT64PrefixCh: make(chan interface{}, 1),
 	}
+	nx.Reset()
+	return nx
 }
 
-// getNAT64Prefix returns previously fetched ipv6 prefix, or gets a fresh one using DNS lookup
-func getNAT64Prefix() []byte {
-	nat64PrefixMx.RLock()
-	defer nat64PrefixMx.RUnlock()
-	return nat64Prefix
-}
-
-// convertAddressDNS64 takes the IP address, converts it to ipv6 and applies DNS64 prefix
-func convertAddressDNS64(prefix []byte, addr string) string {
-	if prefix == nil {
-		return addr
-	}
-	host, port, err := net.SplitHostPort(addr)
-	if err != nil {
-		return addr
-	}
-	ip := net.ParseIP(host)
-	if ip.To4() == nil { // if it's ipv6 already - don't do anything
-		return addr
-	}
-	if ipt.IsPrivate(&net.IPAddr{
-		IP: ip,
-	}) {
-		// don't mess with private IP addresses
-		return addr
-	}
-	ipv6 := ip.To16()
-	copy(ipv6[:12], prefix)
-	return net.JoinHostPort(ipv6.String(), port)
+// Dial is like DialTimeout using a default timeout of 1 minute.
+func Dial(network string, addr string) (net.Conn, error) {
+	return defaultNetx.Dial(network, addr)
 }
 
 // Dial is like DialTimeout using a default timeout of 1 minute.
-func Dial(network string, addr string) (net.Conn, error) {
-	return DialTimeout(network, addr, defaultDialTimeout)
+func (nx *Netx) Dial(network string, addr string) (net.Conn, error) {
+	return nx.DialTimeout(network, addr, defaultDialTimeout)
 }
 
 // DialUDP acts like Dial but for UDP networks.
 func DialUDP(network string, laddr, raddr *net.UDPAddr) (*net.UDPConn, error) {
-	return dialUDP.Load().(func(string, *net.UDPAddr, *net.UDPAddr) (*net.UDPConn, error))(network, laddr, raddr)
+	return defaultNetx.DialUDP(network, laddr, raddr)
+}
+
+// DialUDP acts like Dial but for UDP networks. It's like DialUDPContext without
+// a context.
+func (nx *Netx) DialUDP(network string, laddr, raddr *net.UDPAddr) (*net.UDPConn, error) {
+	return nx.DialUDPContext(context.Background(), network, laddr, raddr)
 }
 
 // DialTimeout dials the given addr on the given net type using the configured
 // dial function, timing out after the given timeout.
 func DialTimeout(network string, addr string, timeout time.Duration) (net.Conn, error) {
+	return defaultNetx.DialTimeout(network, addr, timeout)
+}
+
+// DialTimeout dials the given addr on the given net type using the configured
+// dial function, timing out after the given timeout.
+func (nx *Netx) DialTimeout(network string, addr string, timeout time.Duration) (net.Conn, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), timeout)
-	conn, err := DialContext(ctx, network, addr)
+	conn, err := nx.DialContext(ctx, network, addr)
 
 	cancel()
 	return conn, err
@@ -152,52 +117,167 @@ func DialTimeout(network string, addr string, timeout time.Duration) (net.Conn,
 // DialContext dials the given addr on the given net type using the configured
 // dial function, with the given context.
 func DialContext(ctx context.Context, network string, addr string) (net.Conn, error) {
+	return defaultNetx.DialContext(ctx, network, addr)
+}
+
+// DialContext dials the given addr on the given net type using the configured
+// dial function, with the given context.
+func (nx *Netx) DialContext(ctx context.Context, network string, addr string) (net.Conn, error) {
+	if policy := nx.getRetryPolicy(); policy != nil {
+		return nx.dialWithRetries(ctx, network, addr, policy)
+	}
+	return nx.dialOnce(ctx, network, addr)
+}
+
+func (nx *Netx) dialOnce(ctx context.Context, network string, addr string) (net.Conn, error) {
+	cb := nx.getCircuitBreaker()
+	if cb == nil {
+		return nx.dialDestination(ctx, network, addr)
+	}
+	if err := cb.allow(addr); err != nil {
+		return nil, &DialError{Network: network, Addr: addr, Err: err}
+	}
+	conn, err := nx.dialDestination(ctx, network, addr)
+	whenConnected(conn, err, func(err error) {
+		cb.done(addr, err)
+	})
+	return conn, err
+}
+
+func (nx *Netx) dialDestination(ctx context.Context, network string, addr string) (net.Conn, error) {
+	if delay, ok := nx.happyEyeballsEnabled(network, addr); ok {
+		conn, err := nx.dialHappyEyeballs(ctx, network, addr, delay)
+		if err != nil {
+			nx.refreshNAT64Prefix()
+		}
+		return conn, err
+	}
+
+	dialAddr := addr
+	if nx.getIPSelector() != nil || nx.isHostOverridden(addr) {
+		// resolve here so that the selector gets to pick the IP and so that the
+		// dial function doesn't bypass the hosts override
+		var err error
+		dialAddr, err = nx.resolveForDial(ctx, network, addr)
+		if err != nil {
+			return nil, &DialError{Network: network, Addr: addr, Err: err}
+		}
+	}
+
 	// always convert IPv4 addresses to use a NAT64 prefix if we're on a NAT64 network
 	// if EnableNAT64Autodiscovery hasn't been called, if addr is an IPv6 address, if
 	// addr is a local address or if we haven't autodiscovered a NAT64 prefix, this is a
 	// no-op.
-	prefix := getNAT64Prefix()
-	addrWithPrefix := convertAddressDNS64(prefix, addr)
-	dialer := dial.Load().(func(context.Context, string, string) (net.Conn, error))
-	conn, err := dialer(ctx, network, addrWithPrefix)
-	if err != nil {
-		// we might have a prefix but no ipv6 connectivity, so try ipv4 as fallback
-		if prefix != nil {
-			conn, err = dialer(ctx, network, addr)
-		}
-		// if we still can't connect, return the error, but also trigger a refresh of the prefix
-		if err != nil {
-			// error might be because we're now on a NAT64 network (or a different NAT64 network)
-			// request a refresh of the NAT64 prefix
-			refreshNAT64Prefix()
+	prefix := nx.getNAT64Prefix()
+	addrWithPrefix := convertAddressDNS64(prefix, dialAddr)
+	dialer := nx.getDialer()
+	conn, attempt := dialAndRecord(ctx, dialer, network, addr, addrWithPrefix, addrWithPrefix != dialAddr)
+	nx.reportDialOutcome(conn, attempt, dialAddr)
+	if attempt.Err == nil {
+		return conn, nil
+	}
+	dialErr := &DialError{Network: network, Addr: addr, Attempts: []DialAttempt{attempt}}
+	// we might have a prefix but no ipv6 connectivity, so try ipv4 as fallback
+	if addrWithPrefix != dialAddr {
+		conn, attempt = dialAndRecord(ctx, dialer, network, addr, dialAddr, false)
+		nx.reportDialOutcome(conn, attempt, dialAddr)
+		if attempt.Err == nil {
+			return conn, nil
 		}
-
+		dialErr.Attempts = append(dialErr.Attempts, attempt)
 	}
-	return conn, err
+	// if we still can't connect, return the error, but also trigger a refresh of the prefix.
+	// error might be because we're now on a NAT64 network (or a different NAT64 network)
+	// request a refresh of the NAT64 prefix
+	nx.refreshNAT64Prefix()
+	return nil, dialErr
 }
 
 // ListenUDP acts like ListenPacket for UDP networks.
 func ListenUDP(network string, laddr *net.UDPAddr) (*net.UDPConn, error) {
-	return listenUDP.Load().(func(network string, laddr *net.UDPAddr) (*net.UDPConn, error))(network, laddr)
+	return defaultNetx.ListenUDP(network, laddr)
+}
+
+// ListenUDP acts like ListenPacket for UDP networks. It's like
+// ListenPacketContext without a context.
+func (nx *Netx) ListenUDP(network string, laddr *net.UDPAddr) (*net.UDPConn, error) {
+	addr := ""
+	if laddr != nil {
+		addr = laddr.String()
+	}
+	conn, err := nx.ListenPacketContext(context.Background(), network, addr)
+	if err != nil {
+		return nil, err
+	}
+	udpConn, ok := conn.(*net.UDPConn)
+	if !ok {
+		conn.Close()
+		return nil, errors.New("Listen function returned %T instead of *net.UDPConn", conn)
+	}
+	return udpConn, nil
 }
 
 // OverrideDial overrides the global dial function.
 func OverrideDial(dialFN func(ctx context.Context, net string, addr string) (net.Conn, error)) {
-	dial.Store(dialFN)
+	defaultNetx.OverrideDial(dialFN)
+}
+
+// OverrideDial overrides this Netx's dial function.
+func (nx *Netx) OverrideDial(dialFN func(ctx context.Context, net string, addr string) (net.Conn, error)) {
+	nx.dialMiddleware.setBase(dialFN)
 }
 
 // OverrideDialUDP overrides the global dialUDP function.
 func OverrideDialUDP(dialFN func(net string, laddr, raddr *net.UDPAddr) (*net.UDPConn, error)) {
-	dialUDP.Store(dialFN)
+	defaultNetx.OverrideDialUDP(dialFN)
+}
+
+// OverrideDialUDP overrides this Netx's dialUDP function. It's like
+// OverrideDialUDPContext for functions that don't take a context.
+func (nx *Netx) OverrideDialUDP(dialFN func(net string, laddr, raddr *net.UDPAddr) (*net.UDPConn, error)) {
+	nx.OverrideDialUDPContext(func(ctx context.Context, network string, laddr, raddr *net.UDPAddr) (*net.UDPConn, error) {
+		return dialFN(network, laddr, raddr)
+	})
 }
 
 // OverrideListenUDP overrides the global listenUDP function.
 func OverrideListenUDP(listenFN func(network string, laddr *net.UDPAddr) (*net.UDPConn, error)) {
-	listenUDP.Store(listenFN)
+	defaultNetx.OverrideListenUDP(listenFN)
+}
+
+// OverrideListenUDP overrides this Netx's listenUDP function. It's like
+// OverrideListenPacket for functions that only listen on UDP networks and don't
+// take a context. Listening on other networks fails.
+func (nx *Netx) OverrideListenUDP(listenFN func(network string, laddr *net.UDPAddr) (*net.UDPConn, error)) {
+	nx.OverrideListenPacket(func(ctx context.Context, network string, addr string) (net.PacketConn, error) {
+		switch network {
+		case "udp", "udp4", "udp6":
+		default:
+			return nil, errors.New("Unsupported network: %v", network)
+		}
+		var laddr *net.UDPAddr
+		if addr != "" {
+			var err error
+			laddr, err = net.ResolveUDPAddr(network, addr)
+			if err != nil {
+				return nil, err
+			}
+		}
+		conn, err := listenFN(network, laddr)
+		if err != nil {
+			return nil, err
+		}
+		return conn, nil
+	})
 }
 
 // Resolve resolves the given tcp address using the configured resolve function.
 func Resolve(network string, addr string) (*net.TCPAddr, error) {
+	return defaultNetx.Resolve(network, addr)
+}
+
+// Resolve resolves the given tcp address using the configured resolve function.
+func (nx *Netx) Resolve(network string, addr string) (*net.TCPAddr, error) {
 	switch network {
 	case "tcp", "tcp4", "tcp6":
 		break
@@ -207,15 +287,54 @@ func Resolve(network string, addr string) (*net.TCPAddr, error) {
 		return nil, errors.New("Unsupported network: %v", network)
 	}
 
-	ip, port, err := resolve(network, addr)
+	ip, zone, port, err := nx.resolve(context.Background(), network, addr)
 	if err != nil {
 		return nil, err
 	}
 
-	return &net.TCPAddr{IP: ip, Port: port}, nil
+	return &net.TCPAddr{IP: ip, Port: port, Zone: zone}, nil
+}
+
+// ResolveAllTCP resolves the given tcp address to all of its IPs using the
+// configured resolve function.
+func ResolveAllTCP(network string, addr string) ([]*net.TCPAddr, error) {
+	return defaultNetx.ResolveAllTCP(network, addr)
 }
 
+// ResolveAllTCP resolves the given tcp address to all of its IPs using the
+// configured resolve function. The addresses are ordered according to the
+// FamilyPreference, so callers can try them in turn.
+func (nx *Netx) ResolveAllTCP(network string, addr string) ([]*net.TCPAddr, error) {
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+		break
+	case "":
+		network = "tcp"
+	default:
+		return nil, errors.New("Unsupported network: %v", network)
+	}
+
+	r, err := nx.resolveAll(context.Background(), network, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	addrs := make([]*net.TCPAddr, 0, len(r.ips))
+	for _, ip := range r.ips {
+		addrs = append(addrs, &net.TCPAddr{IP: ip, Port: r.port, Zone: r.zone})
+	}
+	return addrs, nil
+}
+
+// ResolveUDPAddr resolves the given udp address using the configured resolve
+// function.
 func ResolveUDPAddr(network string, addr string) (*net.UDPAddr, error) {
+	return defaultNetx.ResolveUDPAddr(network, addr)
+}
+
+// ResolveUDPAddr resolves the given udp address using the configured resolve
+// function.
+func (nx *Netx) ResolveUDPAddr(network string, addr string) (*net.UDPAddr, error) {
 	switch network {
 	case "udp", "udp4", "udp6":
 		break
@@ -225,64 +344,211 @@ func ResolveUDPAddr(network string, addr string) (*net.UDPAddr, error) {
 		return nil, errors.New("Unsupported network: %v", network)
 	}
 
-	ip, port, err := resolve(network, addr)
+	ip, zone, port, err := nx.resolve(context.Background(), network, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	return &net.UDPAddr{IP: ip, Port: port, Zone: zone}, nil
+}
+
+// ResolveAllUDP resolves the given udp address to all of its IPs using the
+// configured resolve function.
+func ResolveAllUDP(network string, addr string) ([]*net.UDPAddr, error) {
+	return defaultNetx.ResolveAllUDP(network, addr)
+}
+
+// ResolveAllUDP resolves the given udp address to all of its IPs using the
+// configured resolve function. The addresses are ordered according to the
+// FamilyPreference, so callers can try them in turn.
+func (nx *Netx) ResolveAllUDP(network string, addr string) ([]*net.UDPAddr, error) {
+	switch network {
+	case "udp", "udp4", "udp6":
+		break
+	case "":
+		network = "udp"
+	default:
+		return nil, errors.New("Unsupported network: %v", network)
+	}
+
+	r, err := nx.resolveAll(context.Background(), network, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	addrs := make([]*net.UDPAddr, 0, len(r.ips))
+	for _, ip := range r.ips {
+		addrs = append(addrs, &net.UDPAddr{IP: ip, Port: r.port, Zone: r.zone})
+	}
+	return addrs, nil
+}
+
+// ResolveIPAddr resolves the given host on an ip network using the configured
+// resolve function.
+func ResolveIPAddr(network string, host string) (*net.IPAddr, error) {
+	return defaultNetx.ResolveIPAddr(network, host)
+}
+
+// ResolveIPAddr resolves the given host on an ip network using the configured
+// resolve function.
+func (nx *Netx) ResolveIPAddr(network string, host string) (*net.IPAddr, error) {
+	switch network {
+	case "ip", "ip4", "ip6":
+		break
+	case "":
+		network = "ip"
+	default:
+		return nil, errors.New("Unsupported network: %v", network)
+	}
+
+	r, err := nx.resolveHost(context.Background(), network, host)
 	if err != nil {
 		return nil, err
 	}
 
-	return &net.UDPAddr{IP: ip, Port: port}, nil
+	return &net.IPAddr{IP: nx.selectIP(r.host, nx.getFamilyPreference().preferred(r.ips)), Zone: r.zone}, nil
 }
 
-func resolve(network, addr string) (net.IP, int, error) {
+// ResolveUnixAddr resolves the given unix address. Since unix addresses are
+// paths, this never involves a lookup.
+func ResolveUnixAddr(network string, addr string) (*net.UnixAddr, error) {
+	return defaultNetx.ResolveUnixAddr(network, addr)
+}
+
+// ResolveUnixAddr resolves the given unix address. Since unix addresses are
+// paths, this never involves a lookup.
+func (nx *Netx) ResolveUnixAddr(network string, addr string) (*net.UnixAddr, error) {
+	switch network {
+	case "unix", "unixgram", "unixpacket":
+		break
+	case "":
+		network = "unix"
+	default:
+		return nil, errors.New("Unsupported network: %v", network)
+	}
+
+	return &net.UnixAddr{Name: addr, Net: network}, nil
+}
+
+// resolve resolves addr to a single IP, its zone and the port.
+func (nx *Netx) resolve(ctx context.Context, network, addr string) (net.IP, string, int, error) {
+	r, err := nx.resolveAll(ctx, network, addr)
+	if err != nil {
+		return nil, "", 0, err
+	}
+	return nx.selectIP(r.host, nx.getFamilyPreference().preferred(r.ips)), r.zone, r.port, nil
+}
+
+// resolved is the result of resolving a host and port.
+type resolved struct {
+	host string
+	ips  []net.IP
+	// zone is the IPv6 zone, which only IP literals can have
+	zone string
+	port int
+}
+
+// resolveAll resolves all IPs for the host in addr that are usable on the given
+// network, in order of preference. IP literals are used as is.
+func (nx *Netx) resolveAll(ctx context.Context, network, addr string) (*resolved, error) {
 	host, _port, err := net.SplitHostPort(addr)
 	if err != nil {
-		return nil, 0, errors.New("Unable to parse addr %v: %v", addr, err)
+		return nil, errors.New("Unable to parse addr %v: %v", addr, err)
 	}
 	port, err := strconv.Atoi(_port)
 	if err != nil {
-		return nil, 0, errors.New("Unable to convert port %v to integer: %v", _port, err)
+		return nil, errors.New("Unable to convert port %v to integer: %v", _port, err)
 	}
-	ips, err := resolveIPs.Load().(func(string) ([]net.IP, error))(host)
+	r, err := nx.resolveHost(ctx, network, host)
 	if err != nil {
-		return nil, 0, errors.New("Unable to resolve IP for %v: %v", host, err)
+		return nil, err
+	}
+	r.port = port
+	return r, nil
+}
+
+// resolveHost resolves all IPs for host that are usable on the given network,
+// in order of preference. IP literals are used as is.
+func (nx *Netx) resolveHost(ctx context.Context, network, host string) (*resolved, error) {
+	var ips []net.IP
+	var err error
+	ip, zone := parseIPZone(host)
+	if ip != nil {
+		ips = []net.IP{ip}
+	} else {
+		ips, err = nx.getResolver().LookupIP(ctx, ipNetworkFor(network), host)
+		if err != nil {
+			return nil, errors.New("Unable to resolve IP for %v: %v", host, err)
+		}
 	}
 	switch network {
-	case "tcp4", "udp4":
+	case "tcp4", "udp4", "ip4":
 		ips = ipv4Only(ips)
-	case "tcp6", "udp6":
+	case "tcp6", "udp6", "ip6":
 		ips = ipv6Only(ips)
-	case "tcp", "udp":
-		// When the IP version is ambiguous, we prefer IPv4. This is the same choice that Go's net
-		// package makes. Note that localhost can be a special case: for most hosts, the IPv6
-		// address will still reach the server. This is often not the case for localhost; a local
-		// server is likely to only listen over IPv4, but DNS will resolve both the IPv4 and IPv6
-		// addresses. In this case, it can be especially important to prefer IPv4.
-		if ipv4s := ipv4Only(ips); len(ipv4s) > 0 {
-			ips = ipv4s
-		}
+	case "tcp", "udp", "ip":
+		// When the IP version is ambiguous, it's up to the FamilyPreference.
+		ips = nx.getFamilyPreference().order(ips)
 	}
 	if len(ips) == 0 {
-		return nil, 0, errors.New("unable to resolve IP for %v (%v): %v", host, network, err)
+		return nil, errors.New("unable to resolve IP for %v (%v): %v", host, network, err)
+	}
+	return &resolved{host: host, ips: ips, zone: zone}, nil
+}
+
+// resolveForDial resolves the host in addr, if it's not already an IP, and
+// returns the address with the selected IP.
+func (nx *Netx) resolveForDial(ctx context.Context, network, addr string) (string, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		// leave it to the dial function
+		return addr, nil
+	}
+	if ip, _ := parseIPZone(host); ip != nil {
+		return addr, nil
+	}
+	switch network {
+	case "tcp", "tcp4", "tcp6", "udp", "udp4", "udp6":
+	default:
+		return addr, nil
 	}
-	ip, err := pickRandomIP(ips)
+	ip, _, port, err := nx.resolve(ctx, network, addr)
 	if err != nil {
-		return nil, 0, err
+		return "", err
 	}
-	return ip, port, nil
+	return net.JoinHostPort(ip.String(), strconv.Itoa(port)), nil
 }
 
-// OverrideResolveIPs overrides the global IP resolution function.
+// OverrideResolveIPs overrides the global IP resolution function. It is
+// equivalent to calling OverrideResolver with a ResolveIPsFunc.
 func OverrideResolveIPs(resolveFN func(host string) ([]net.IP, error)) {
-	resolveIPs.Store(resolveFN)
+	defaultNetx.OverrideResolveIPs(resolveFN)
+}
+
+// OverrideResolveIPs overrides this Netx's IP resolution function.
+func (nx *Netx) OverrideResolveIPs(resolveFN func(host string) ([]net.IP, error)) {
+	nx.OverrideResolver(ResolveIPsFunc(resolveFN))
 }
 
 // Reset resets netx to its default settings
 func Reset() {
-	var d net.Dialer
-	OverrideDial(d.DialContext)
-	OverrideDialUDP(net.DialUDP)
-	OverrideListenUDP(net.ListenUDP)
-	OverrideResolveIPs(net.LookupIP)
+	defaultNetx.Reset()
+}
+
+// Reset resets this Netx to its default settings
+func (nx *Netx) Reset() {
+	nx.OverrideDial(dialWithOptions)
+	nx.OverrideDialUDPContext(dialUDPContext)
+	nx.OverrideListenPacket(listenPacketContext)
+	nx.OverrideResolver(net.DefaultResolver)
+	nx.DisableHappyEyeballs()
+	nx.SetRetryPolicy(nil)
+	nx.DisableCircuitBreaker()
+	nx.SetIPSelector(nil)
+	nx.SetFamilyPreference(PreferIPv4)
+	nx.SetHostsOverride(nil)
+	nx.resetMiddleware()
+	nx.resetNAT64()
 }
 
 func pickRandomIP(ips []net.IP) (net.IP, error) {
@@ -1,6 +1,7 @@
 package netx
 
 import (
+	"net"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -30,3 +31,27 @@ func TestResolveLocalhost(t *testing.T) {
 		require.Nil(t, udpAddr.IP.To4(), "IP (%v) seems to be IPv4, but should be IPv6", udpAddr.IP)
 	}
 }
+
+func TestInstancesAreIndependent(t *testing.T) {
+	a := New()
+	b := New()
+	a.OverrideResolveIPs(func(host string) ([]net.IP, error) {
+		return []net.IP{net.ParseIP("1.1.1.1")}, nil
+	})
+	b.OverrideResolveIPs(func(host string) ([]net.IP, error) {
+		return []net.IP{net.ParseIP("2.2.2.2")}, nil
+	})
+
+	addr, err := a.Resolve("tcp", "example.com:80")
+	require.NoError(t, err)
+	require.Equal(t, "1.1.1.1:80", addr.String())
+
+	addr, err = b.Resolve("tcp", "example.com:80")
+	require.NoError(t, err)
+	require.Equal(t, "2.2.2.2:80", addr.String())
+
+	b.Reset()
+	addr, err = a.Resolve("tcp", "example.com:80")
+	require.NoError(t, err)
+	require.Equal(t, "1.1.1.1:80", addr.String(), "resetting one instance should not affect another")
+}
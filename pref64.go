@@ -0,0 +1,104 @@
+package netx
+
+import (
+	"encoding/binary"
+	"net"
+	"time"
+
+	"github.com/getlantern/errors"
+)
+
+// This file parses the PREF64 option (RFC 8781) out of ICMPv6 router
+// advertisements (RFC 4861 section 4.2).
+
+const (
+	icmpv6TypeRouterAdvertisement = 134
+	routerAdvertisementHeaderLen  = 16
+	ndOptionTypePREF64            = 38
+	pref64OptionLen               = 16
+)
+
+// PREF64 is a NAT64 prefix advertised by a router along with how long it's
+// valid for. A Lifetime of 0 means that the router withdrew the prefix.
+type PREF64 struct {
+	Prefix   *NAT64Prefix
+	Lifetime time.Duration
+}
+
+// ParseRouterAdvertisement parses the raw ICMPv6 router advertisement in msg,
+// starting with the ICMPv6 type field, and returns any PREF64 options that it
+// contains. As required by RFC 8781 section 4, invalid PREF64 options are
+// ignored rather than failing the whole advertisement. Only a malformed
+// advertisement or option framing is an error.
+func ParseRouterAdvertisement(msg []byte) ([]PREF64, error) {
+	if len(msg) < routerAdvertisementHeaderLen {
+		return nil, errors.New("Router advertisement too short: %d bytes", len(msg))
+	}
+	if msg[0] != icmpv6TypeRouterAdvertisement {
+		return nil, errors.New("Not a router advertisement, ICMPv6 type %d", msg[0])
+	}
+
+	var result []PREF64
+	options := msg[routerAdvertisementHeaderLen:]
+	for len(options) > 0 {
+		if len(options) < 2 {
+			return nil, errors.New("Router advertisement option truncated")
+		}
+		// option length is in units of 8 octets and includes the type and length
+		optionLen := int(options[1]) * 8
+		if optionLen == 0 || optionLen > len(options) {
+			return nil, errors.New("Router advertisement option has invalid length %d", optionLen)
+		}
+		if options[0] == ndOptionTypePREF64 {
+			pref64, err := ParsePREF64Option(options[:optionLen])
+			if err != nil {
+				log.Debugf("Ignoring PREF64 option: %v", err)
+			} else {
+				result = append(result, pref64)
+			}
+		}
+		options = options[optionLen:]
+	}
+	return result, nil
+}
+
+// ParsePREF64Option parses a single PREF64 option (RFC 8781 section 4),
+// starting with its type field.
+func ParsePREF64Option(option []byte) (PREF64, error) {
+	if len(option) < pref64OptionLen || option[0] != ndOptionTypePREF64 || int(option[1])*8 != pref64OptionLen {
+		return PREF64{}, errors.New("Invalid PREF64 option")
+	}
+	scaledLifetimePLC := binary.BigEndian.Uint16(option[2:])
+	lifetime := time.Duration(scaledLifetimePLC>>3) * 8 * time.Second
+	bits, ok := pref64PrefixLength(scaledLifetimePLC & 0x7)
+	if !ok {
+		return PREF64{}, errors.New("Invalid PREF64 prefix length code %d", scaledLifetimePLC&0x7)
+	}
+	ip := make(net.IP, net.IPv6len)
+	copy(ip, option[4:pref64OptionLen])
+	prefix, ok := NewNAT64Prefix(ip, bits)
+	if !ok {
+		return PREF64{}, errors.New("Invalid PREF64 prefix %v/%d", ip, bits)
+	}
+	return PREF64{Prefix: prefix, Lifetime: lifetime}, nil
+}
+
+// pref64PrefixLength maps a Prefix Length Code to a prefix length.
+func pref64PrefixLength(plc uint16) (int, bool) {
+	switch plc {
+	case 0:
+		return 96, true
+	case 1:
+		return 64, true
+	case 2:
+		return 56, true
+	case 3:
+		return 48, true
+	case 4:
+		return 40, true
+	case 5:
+		return 32, true
+	default:
+		return 0, false
+	}
+}
@@ -0,0 +1,125 @@
+package netx
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func buildPREF64Option(prefix string, plc uint16, lifetime time.Duration) []byte {
+	option := make([]byte, pref64OptionLen)
+	option[0] = ndOptionTypePREF64
+	option[1] = pref64OptionLen / 8
+	binary.BigEndian.PutUint16(option[2:], uint16(lifetime/time.Second/8)<<3|plc)
+	copy(option[4:], net.ParseIP(prefix)[:12])
+	return option
+}
+
+func buildRouterAdvertisement(options ...[]byte) []byte {
+	msg := make([]byte, routerAdvertisementHeaderLen)
+	msg[0] = icmpv6TypeRouterAdvertisement
+	// a source link-layer address option that should be skipped
+	msg = append(msg, 1, 1, 0x02, 0x00, 0x00, 0x00, 0x00, 0x01)
+	for _, option := range options {
+		msg = append(msg, option...)
+	}
+	return msg
+}
+
+func TestParseRouterAdvertisement(t *testing.T) {
+	msg := buildRouterAdvertisement(
+		buildPREF64Option("64:ff9b::", 0, 1800*time.Second),
+		buildPREF64Option("2001:db8:122:344::", 1, 600*time.Second),
+	)
+	pref64s, err := ParseRouterAdvertisement(msg)
+	require.NoError(t, err)
+	require.Len(t, pref64s, 2)
+	assert.Equal(t, "64:ff9b::/96", pref64s[0].Prefix.String())
+	assert.Equal(t, 1800*time.Second, pref64s[0].Lifetime)
+	assert.Equal(t, "2001:db8:122:344::/64", pref64s[1].Prefix.String())
+	assert.Equal(t, 600*time.Second, pref64s[1].Lifetime)
+
+	_, err = ParseRouterAdvertisement(msg[:10])
+	assert.Error(t, err)
+	_, err = ParseRouterAdvertisement(msg[:len(msg)-4])
+	assert.Error(t, err, "truncated option should be rejected")
+	_, err = ParsePREF64Option(buildPREF64Option("64:ff9b::", 6, time.Hour))
+	assert.Error(t, err, "invalid prefix length code should be rejected")
+	_, err = ParsePREF64Option(buildPREF64Option("::ffff:0:0", 0, time.Hour))
+	assert.Error(t, err, "IPv4-mapped prefix should be rejected")
+}
+
+func TestParseRouterAdvertisementIgnoresInvalidPREF64(t *testing.T) {
+	badLength := append(buildPREF64Option("2001:db8:1::", 0, time.Hour), make([]byte, 8)...)
+	badLength[1] = 3
+	msg := buildRouterAdvertisement(
+		buildPREF64Option("2001:db8:2::", 6, time.Hour),
+		badLength,
+		buildPREF64Option("::ffff:0:0", 0, time.Hour),
+		buildPREF64Option("64:ff9b::", 0, time.Hour),
+	)
+	pref64s, err := ParseRouterAdvertisement(msg)
+	require.NoError(t, err, "invalid PREF64 options shouldn't fail the advertisement")
+	require.Len(t, pref64s, 1)
+	assert.Equal(t, "64:ff9b::/96", pref64s[0].Prefix.String())
+
+	nx := New()
+	require.NoError(t, nx.HandleRouterAdvertisement(msg))
+	assert.Equal(t, "64:ff9b::/96", nx.NAT64Status().Prefix.String())
+}
+
+func TestHandleRouterAdvertisementInvalidPrefix(t *testing.T) {
+	nx := New()
+	msg := buildRouterAdvertisement(buildPREF64Option("::ffff:0:0", 0, time.Hour))
+	assert.NoError(t, nx.HandleRouterAdvertisement(msg), "invalid option should be ignored")
+	assert.Equal(t, NAT64SourceNone, nx.NAT64Status().Source)
+	assert.Empty(t, nx.NAT64Status().Prefixes)
+
+	// a subsequent valid advertisement is still handled
+	require.NoError(t, nx.HandleRouterAdvertisement(buildRouterAdvertisement(
+		buildPREF64Option("64:ff9b::", 0, time.Hour))))
+	assert.Equal(t, "64:ff9b::/96", nx.NAT64Status().Prefix.String())
+
+	nx.SetNAT64Prefix(nil)
+	assert.Equal(t, NAT64SourceRouterAdvertisement, nx.NAT64Status().Source, "nil static prefixes should be ignored")
+}
+
+func TestNAT64PrefixSourcePriority(t *testing.T) {
+	nx := New()
+	_, source := nx.currentNAT64Prefixes()
+	assert.Equal(t, NAT64SourceNone, source)
+
+	dnsPrefix, _ := NewNAT64Prefix(net.ParseIP("64:ff9b::"), 96)
+	nx.nat64DNSPrefixes = []*NAT64Prefix{dnsPrefix}
+	prefixes, source := nx.currentNAT64Prefixes()
+	assert.Equal(t, NAT64SourceDNS, source)
+	assert.Equal(t, "[64:ff9b::/96]", formatNAT64Prefixes(prefixes))
+
+	require.NoError(t, nx.HandleRouterAdvertisement(buildRouterAdvertisement(
+		buildPREF64Option("2001:db8:1::", 0, time.Hour))))
+	prefixes, source = nx.currentNAT64Prefixes()
+	assert.Equal(t, NAT64SourceRouterAdvertisement, source)
+	assert.Equal(t, "[2001:db8:1::/96]", formatNAT64Prefixes(prefixes))
+
+	staticPrefix, err := ParseNAT64Prefix("2001:db8:2::/64")
+	require.NoError(t, err)
+	nx.SetNAT64Prefix(staticPrefix)
+	prefixes, source = nx.currentNAT64Prefixes()
+	assert.Equal(t, NAT64SourceStatic, source)
+	assert.Equal(t, "[2001:db8:2::/64]", formatNAT64Prefixes(prefixes))
+	assert.Equal(t, staticPrefix, nx.getNAT64Prefix())
+
+	// removing the static prefix and withdrawing the advertised one falls back to DNS
+	nx.SetNAT64Prefix()
+	require.NoError(t, nx.HandleRouterAdvertisement(buildRouterAdvertisement(
+		buildPREF64Option("2001:db8:1::", 0, 0))))
+	_, source = nx.currentNAT64Prefixes()
+	assert.Equal(t, NAT64SourceDNS, source)
+
+	_, err = ParseNAT64Prefix("2001:db8::/80")
+	assert.Error(t, err)
+}
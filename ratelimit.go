@@ -0,0 +1,114 @@
+package netx
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// RateLimiter is a token bucket that limits how fast BidiCopy copies data. It
+// is safe for concurrent use, so a single RateLimiter can be shared by many
+// copies to cap their combined throughput, and its rate and burst can be
+// changed while they're running.
+type RateLimiter struct {
+	mx      sync.Mutex
+	rate    float64
+	burst   int
+	tokens  float64
+	last    time.Time
+	changed chan struct{}
+}
+
+// NewRateLimiter creates a RateLimiter that allows bytesPerSecond on average,
+// in bursts of up to burst bytes. A bytesPerSecond of zero or less means no
+// limit. A burst of zero or less defaults to bytesPerSecond, i.e. one second's
+// worth of data.
+func NewRateLimiter(bytesPerSecond int64, burst int) *RateLimiter {
+	l := &RateLimiter{changed: make(chan struct{})}
+	l.rate, l.burst = limiterSettings(bytesPerSecond, burst)
+	l.tokens = float64(l.burst)
+	l.last = time.Now()
+	return l
+}
+
+// SetRate changes the rate and burst of l, see NewRateLimiter. Copies waiting
+// on l pick up the new settings immediately.
+func (l *RateLimiter) SetRate(bytesPerSecond int64, burst int) {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+	l.refill(time.Now())
+	l.rate, l.burst = limiterSettings(bytesPerSecond, burst)
+	if l.tokens > float64(l.burst) {
+		l.tokens = float64(l.burst)
+	}
+	close(l.changed)
+	l.changed = make(chan struct{})
+}
+
+// Rate returns the current rate in bytes per second and burst in bytes of l.
+func (l *RateLimiter) Rate() (bytesPerSecond int64, burst int) {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+	return int64(l.rate), l.burst
+}
+
+func limiterSettings(bytesPerSecond int64, burst int) (float64, int) {
+	if burst <= 0 {
+		burst = int(bytesPerSecond)
+	}
+	if burst <= 0 {
+		burst = 1
+	}
+	return float64(bytesPerSecond), burst
+}
+
+// WaitN blocks until n bytes may be copied or ctx is done, in which case it
+// returns ctx's error. Requests bigger than the burst are granted in
+// burst-sized chunks.
+func (l *RateLimiter) WaitN(ctx context.Context, n int) error {
+	for n > 0 {
+		l.mx.Lock()
+		if l.rate <= 0 {
+			l.mx.Unlock()
+			return nil
+		}
+		now := time.Now()
+		l.refill(now)
+		chunk := n
+		if chunk > l.burst {
+			chunk = l.burst
+		}
+		if l.tokens >= float64(chunk) {
+			l.tokens -= float64(chunk)
+			n -= chunk
+			l.mx.Unlock()
+			continue
+		}
+		wait := time.Duration((float64(chunk) - l.tokens) / l.rate * float64(time.Second))
+		changed := l.changed
+		l.mx.Unlock()
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-changed:
+			timer.Stop()
+		case <-timer.C:
+		}
+	}
+	return nil
+}
+
+// refill adds the tokens accrued since the last refill. It must be called with
+// l.mx held.
+func (l *RateLimiter) refill(now time.Time) {
+	if l.rate > 0 {
+		l.tokens += now.Sub(l.last).Seconds() * l.rate
+		if l.tokens > float64(l.burst) {
+			l.tokens = float64(l.burst)
+		}
+	}
+	l.last = now
+}
@@ -0,0 +1,100 @@
+package netx
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRateLimiterWaitN(t *testing.T) {
+	l := NewRateLimiter(10000, 1000)
+	start := time.Now()
+	require.NoError(t, l.WaitN(context.Background(), 1000), "burst should be available immediately")
+	assert.True(t, time.Since(start) < 50*time.Millisecond)
+
+	start = time.Now()
+	require.NoError(t, l.WaitN(context.Background(), 2000), "requests bigger than the burst should be split")
+	elapsed := time.Since(start)
+	assert.True(t, elapsed >= 190*time.Millisecond, "waited only %v", elapsed)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, l.WaitN(ctx, 1000))
+}
+
+func TestRateLimiterSetRate(t *testing.T) {
+	l := NewRateLimiter(1, 1)
+	rate, burst := l.Rate()
+	assert.EqualValues(t, 1, rate)
+	assert.Equal(t, 1, burst)
+	require.NoError(t, l.WaitN(context.Background(), 1))
+
+	done := make(chan error)
+	go func() {
+		done <- l.WaitN(context.Background(), 100)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	l.SetRate(0, 0)
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("removing the limit should have woken the waiter")
+	}
+}
+
+func TestBidiCopyRateLimited(t *testing.T) {
+	out, outPeer := net.Pipe()
+	defer out.Close()
+	defer outPeer.Close()
+	in, inPeer := net.Pipe()
+	defer in.Close()
+	defer inPeer.Close()
+
+	limiter := NewRateLimiter(20000, 1000)
+	outErrCh, inErrCh := BidiCopyWithOpts(out, in, &CopyOpts{OutLimiter: limiter})
+	go func() {
+		inPeer.Write(make([]byte, 5000))
+		inPeer.Close()
+	}()
+	start := time.Now()
+	_, err := io.ReadFull(outPeer, make([]byte, 5000))
+	require.NoError(t, err)
+	elapsed := time.Since(start)
+	assert.True(t, elapsed >= 190*time.Millisecond, "copied too quickly in %v", elapsed)
+	assert.NoError(t, <-inErrCh)
+	assert.NoError(t, <-outErrCh)
+}
+
+func TestBidiCopyRateLimitedNotIdle(t *testing.T) {
+	out, outPeer := net.Pipe()
+	defer out.Close()
+	defer outPeer.Close()
+	in, inPeer := net.Pipe()
+	defer in.Close()
+	defer inPeer.Close()
+
+	// each read of up to 4000 bytes takes 400ms to get through the limiter,
+	// which is longer than the idle timeout
+	limiter := NewRateLimiter(10000, 4000)
+	require.NoError(t, limiter.WaitN(context.Background(), 4000))
+	outErrCh, inErrCh := BidiCopyWithOpts(out, in, &CopyOpts{
+		OutLimiter:  limiter,
+		IdleTimeout: 150 * time.Millisecond,
+	})
+	go func() {
+		inPeer.Write(make([]byte, 8000))
+		inPeer.Close()
+	}()
+	require.NoError(t, outPeer.SetReadDeadline(time.Now().Add(5*time.Second)))
+	_, err := io.ReadFull(outPeer, make([]byte, 8000))
+	require.NoError(t, err, "throttled copy shouldn't be considered idle")
+	outPeer.Close()
+	assert.NoError(t, <-outErrCh)
+	assert.NoError(t, <-inErrCh)
+}
@@ -0,0 +1,113 @@
+package netx
+
+import (
+	"context"
+	"net"
+	"time"
+)
+
+// Resolver resolves host names to IP addresses. network is one of "ip", "ip4"
+// or "ip6" and limits the lookup to A and AAAA, A only or AAAA only records
+// respectively. *net.Resolver implements this interface.
+type Resolver interface {
+	LookupIP(ctx context.Context, network, host string) ([]net.IP, error)
+}
+
+// TTLResolver is a Resolver that can also report how long the returned
+// addresses may be cached for.
+type TTLResolver interface {
+	Resolver
+
+	// LookupIPTTL is like LookupIP but also returns the TTL of the answer, which
+	// is the smallest TTL of any of the returned records.
+	LookupIPTTL(ctx context.Context, network, host string) ([]net.IP, time.Duration, error)
+}
+
+// ResolveIPsFunc adapts a legacy resolve function like net.LookupIP to the
+// Resolver interface. Addresses are filtered to match the requested network.
+// The function can't be interrupted, but LookupIP returns as soon as ctx is
+// done.
+type ResolveIPsFunc func(host string) ([]net.IP, error)
+
+// LookupIP implements the method from the Resolver interface.
+func (fn ResolveIPsFunc) LookupIP(ctx context.Context, network, host string) ([]net.IP, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	type result struct {
+		ips []net.IP
+		err error
+	}
+	resultCh := make(chan result, 1)
+	go func() {
+		ips, err := fn(host)
+		resultCh <- result{ips, err}
+	}()
+	select {
+	case r := <-resultCh:
+		if r.err != nil {
+			return nil, r.err
+		}
+		return filterIPsForNetwork(network, r.ips), nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// OverrideResolver overrides the global Resolver.
+func OverrideResolver(resolver Resolver) {
+	defaultNetx.OverrideResolver(resolver)
+}
+
+// OverrideResolver overrides this Netx's Resolver.
+func (nx *Netx) OverrideResolver(resolver Resolver) {
+	nx.resolverMiddleware.setBase(resolver)
+}
+
+// getResolver returns this Netx's Resolver wrapped in any middleware, behind
+// IP literals and the hosts override.
+func (nx *Netx) getResolver() Resolver {
+	return &staticResolver{
+		hosts: nx.getHostsOverride(),
+		next:  nx.resolverMiddleware.get(),
+	}
+}
+
+// getBaseResolver returns the Resolver configured with OverrideResolver.
+func (nx *Netx) getBaseResolver() Resolver {
+	return nx.resolverMiddleware.getBase()
+}
+
+// lookupIPTTL looks up the given host using the given resolver, returning a
+// TTL of 0 if the resolver doesn't report TTLs.
+func lookupIPTTL(ctx context.Context, resolver Resolver, network, host string) ([]net.IP, time.Duration, error) {
+	if ttlResolver, ok := resolver.(TTLResolver); ok {
+		return ttlResolver.LookupIPTTL(ctx, network, host)
+	}
+	ips, err := resolver.LookupIP(ctx, network, host)
+	return ips, 0, err
+}
+
+// ipNetworkFor maps a tcp or udp network to the corresponding ip network to
+// use when resolving addresses.
+func ipNetworkFor(network string) string {
+	switch network {
+	case "tcp4", "udp4", "ip4":
+		return "ip4"
+	case "tcp6", "udp6", "ip6":
+		return "ip6"
+	default:
+		return "ip"
+	}
+}
+
+func filterIPsForNetwork(network string, ips []net.IP) []net.IP {
+	switch network {
+	case "ip4":
+		return ipv4Only(ips)
+	case "ip6":
+		return ipv6Only(ips)
+	default:
+		return ips
+	}
+}
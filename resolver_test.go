@@ -0,0 +1,73 @@
+package netx
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type recordingResolver struct {
+	networks []string
+	ips      []net.IP
+}
+
+func (r *recordingResolver) LookupIP(ctx context.Context, network, host string) ([]net.IP, error) {
+	r.networks = append(r.networks, network)
+	return filterIPsForNetwork(network, r.ips), nil
+}
+
+func TestResolveUsesResolverFamily(t *testing.T) {
+	r := &recordingResolver{ips: []net.IP{net.ParseIP("2001:db8::1"), net.ParseIP("192.0.2.1")}}
+	nx := New()
+	nx.OverrideResolver(r)
+
+	addr, err := nx.Resolve("tcp6", "example.com:80")
+	require.NoError(t, err)
+	assert.Equal(t, "[2001:db8::1]:80", addr.String())
+
+	udpAddr, err := nx.ResolveUDPAddr("udp4", "example.com:53")
+	require.NoError(t, err)
+	assert.Equal(t, "192.0.2.1:53", udpAddr.String())
+
+	addr, err = nx.Resolve("tcp", "example.com:80")
+	require.NoError(t, err)
+	assert.Equal(t, "192.0.2.1:80", addr.String())
+
+	assert.Equal(t, []string{"ip6", "ip4", "ip"}, r.networks)
+}
+
+func TestResolveIPsFunc(t *testing.T) {
+	fn := ResolveIPsFunc(func(host string) ([]net.IP, error) {
+		return []net.IP{net.ParseIP("2001:db8::1"), net.ParseIP("192.0.2.1")}, nil
+	})
+
+	ips, err := fn.LookupIP(context.Background(), "ip4", "example.com")
+	require.NoError(t, err)
+	assert.Equal(t, []net.IP{net.ParseIP("192.0.2.1")}, ips)
+
+	ips, err = fn.LookupIP(context.Background(), "ip6", "example.com")
+	require.NoError(t, err)
+	assert.Equal(t, []net.IP{net.ParseIP("2001:db8::1")}, ips)
+
+	ips, err = fn.LookupIP(context.Background(), "ip", "example.com")
+	require.NoError(t, err)
+	assert.Len(t, ips, 2)
+}
+
+func TestResolveIPsFuncCancel(t *testing.T) {
+	unblock := make(chan struct{})
+	defer close(unblock)
+	fn := ResolveIPsFunc(func(host string) ([]net.IP, error) {
+		<-unblock
+		return nil, nil
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := fn.LookupIP(ctx, "ip", "example.com")
+	assert.Equal(t, context.DeadlineExceeded, err)
+}
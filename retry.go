@@ -0,0 +1,185 @@
+package netx
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net"
+	"syscall"
+	"time"
+)
+
+const (
+	// DefaultRetryMaxAttempts is the default maximum number of tries, including
+	// the first one.
+	DefaultRetryMaxAttempts = 3
+	// DefaultRetryInitialBackoff is the default time to wait before the first
+	// retry.
+	DefaultRetryInitialBackoff = 100 * time.Millisecond
+	// DefaultRetryMaxBackoff is the default limit on the time to wait between
+	// tries.
+	DefaultRetryMaxBackoff = 5 * time.Second
+	// DefaultRetryMultiplier is the default factor by which the backoff grows
+	// after each retry.
+	DefaultRetryMultiplier = 2
+	// DefaultRetryJitter is the default fraction by which each backoff is
+	// randomly varied.
+	DefaultRetryJitter = 0.2
+)
+
+// RetryPolicy configures how DialContext retries failed dials. It will use
+// sensible defaults for any missing options.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of tries, including the first one.
+	MaxAttempts int
+	// InitialBackoff is the time to wait before the first retry.
+	InitialBackoff time.Duration
+	// MaxBackoff limits the time to wait between tries.
+	MaxBackoff time.Duration
+	// Multiplier is the factor by which the backoff grows after each retry.
+	Multiplier float64
+	// Jitter is the fraction by which each backoff is randomly varied in either
+	// direction, between 0 and 1. Set it to a negative value to disable jitter.
+	Jitter float64
+	// Retryable decides whether a failed dial should be retried. It defaults to
+	// IsRetryable.
+	Retryable func(err error) bool
+}
+
+func (p *RetryPolicy) ApplyDefaults() {
+	if p.MaxAttempts <= 0 {
+		p.MaxAttempts = DefaultRetryMaxAttempts
+	}
+	if p.InitialBackoff <= 0 {
+		p.InitialBackoff = DefaultRetryInitialBackoff
+	}
+	if p.MaxBackoff <= 0 {
+		p.MaxBackoff = DefaultRetryMaxBackoff
+	}
+	if p.Multiplier < 1 {
+		p.Multiplier = DefaultRetryMultiplier
+	}
+	if p.Jitter == 0 {
+		p.Jitter = DefaultRetryJitter
+	} else if p.Jitter < 0 {
+		p.Jitter = 0
+	} else if p.Jitter > 1 {
+		p.Jitter = 1
+	}
+	if p.Retryable == nil {
+		p.Retryable = IsRetryable
+	}
+}
+
+// backoff returns how long to wait before the given retry, starting at 1.
+func (p *RetryPolicy) backoff(retry int) time.Duration {
+	backoff := float64(p.InitialBackoff)
+	for i := 1; i < retry && backoff < float64(p.MaxBackoff); i++ {
+		backoff *= p.Multiplier
+	}
+	if backoff > float64(p.MaxBackoff) {
+		backoff = float64(p.MaxBackoff)
+	}
+	if p.Jitter > 0 {
+		backoff *= 1 + p.Jitter*(2*rand.Float64()-1)
+	}
+	return time.Duration(backoff)
+}
+
+// IsRetryable returns true if err is a timeout as determined by IsTimeout or
+// is caused by a condition that's likely to be transient, like a refused or
+// reset connection.
+func IsRetryable(err error) bool {
+	if IsTimeout(err) {
+		return true
+	}
+	for _, errno := range []syscall.Errno{syscall.ECONNREFUSED, syscall.ECONNRESET, syscall.ECONNABORTED, syscall.EHOSTUNREACH} {
+		if errors.Is(err, errno) {
+			return true
+		}
+	}
+	return false
+}
+
+// SetRetryPolicy sets the RetryPolicy for the global DialContext. See
+// Netx.SetRetryPolicy.
+func SetRetryPolicy(policy *RetryPolicy) {
+	defaultNetx.SetRetryPolicy(policy)
+}
+
+// SetRetryPolicy makes DialContext retry dials that fail with retryable errors
+// according to the given policy, waiting between tries as long as ctx allows.
+// The DialError that's returned when all tries fail lists the attempts from
+// all tries. A nil policy disables retries, which is the default.
+func (nx *Netx) SetRetryPolicy(policy *RetryPolicy) {
+	if policy != nil {
+		p := *policy
+		p.ApplyDefaults()
+		policy = &p
+	}
+	nx.retryPolicy.Store(retryPolicyHolder{policy})
+}
+
+// retryPolicyHolder allows storing a nil *RetryPolicy in an atomic.Value.
+type retryPolicyHolder struct {
+	policy *RetryPolicy
+}
+
+func (nx *Netx) getRetryPolicy() *RetryPolicy {
+	holder, _ := nx.retryPolicy.Load().(retryPolicyHolder)
+	return holder.policy
+}
+
+func (nx *Netx) dialWithRetries(ctx context.Context, network string, addr string, policy *RetryPolicy) (net.Conn, error) {
+	dialErr := &DialError{Network: network, Addr: addr}
+	for retry := 0; ; retry++ {
+		conn, err := nx.dialOnce(ctx, network, addr)
+		if err == nil {
+			return conn, nil
+		}
+		dialErr.Attempts = append(dialErr.Attempts, attemptsFor(err, network, addr, retry)...)
+		if retry+1 >= policy.MaxAttempts || ctx.Err() != nil || !policy.Retryable(err) {
+			return nil, dialErr
+		}
+
+		backoff := policy.backoff(retry + 1)
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < backoff {
+			// no point waiting if we won't be able to try again
+			return nil, dialErr
+		}
+		log.Debugf("Retrying dial to %v in %v: %v", addr, backoff, err)
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, dialErr
+		case <-timer.C:
+		}
+	}
+}
+
+// attemptsFor returns the attempts recorded in err, marked with the given
+// retry. If err isn't a DialError or one without any attempts, for example
+// because resolving the address failed, it's recorded as a single attempt.
+func attemptsFor(err error, network string, addr string, retry int) []DialAttempt {
+	dialErr, ok := err.(*DialError)
+	if ok && len(dialErr.Attempts) == 0 && dialErr.Err != nil {
+		err, ok = dialErr.Err, false
+	}
+	if !ok {
+		return []DialAttempt{{
+			Network:      network,
+			OriginalAddr: addr,
+			Addr:         addr,
+			Err:          err,
+			Timeout:      IsTimeout(err),
+			Retry:        retry,
+		}}
+	}
+	attempts := make([]DialAttempt, len(dialErr.Attempts))
+	for i, attempt := range dialErr.Attempts {
+		attempt.Retry = retry
+		attempts[i] = attempt
+	}
+	return attempts
+}
@@ -0,0 +1,91 @@
+package netx
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsRetryable(t *testing.T) {
+	assert.True(t, IsRetryable(&net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}))
+	assert.True(t, IsRetryable(fmt.Errorf("wrapped: %w", syscall.ECONNRESET)))
+	assert.True(t, IsRetryable(&timeouterror{}))
+	assert.False(t, IsRetryable(assert.AnError))
+	assert.False(t, IsRetryable(context.Canceled))
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	p := &RetryPolicy{InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second, Jitter: -1}
+	p.ApplyDefaults()
+	assert.Equal(t, 100*time.Millisecond, p.backoff(1))
+	assert.Equal(t, 200*time.Millisecond, p.backoff(2))
+	assert.Equal(t, 800*time.Millisecond, p.backoff(4))
+	assert.Equal(t, time.Second, p.backoff(5))
+
+	p = &RetryPolicy{InitialBackoff: 100 * time.Millisecond, Jitter: 0.5}
+	p.ApplyDefaults()
+	for i := 0; i < 100; i++ {
+		backoff := p.backoff(1)
+		assert.True(t, backoff >= 50*time.Millisecond && backoff <= 150*time.Millisecond, "backoff %v out of range", backoff)
+	}
+}
+
+func TestDialRetries(t *testing.T) {
+	var tries int
+	nx := New()
+	nx.OverrideDial(func(ctx context.Context, network, addr string) (net.Conn, error) {
+		tries++
+		if tries < 3 {
+			return nil, &net.OpError{Op: "dial", Net: network, Err: syscall.ECONNREFUSED}
+		}
+		return &net.TCPConn{}, nil
+	})
+	nx.SetRetryPolicy(&RetryPolicy{InitialBackoff: time.Millisecond})
+
+	conn, err := nx.DialContext(context.Background(), "tcp", "127.0.0.1:1")
+	require.NoError(t, err)
+	assert.NotNil(t, conn)
+	assert.Equal(t, 3, tries)
+
+	tries = -10
+	_, err = nx.DialContext(context.Background(), "tcp", "127.0.0.1:1")
+	var dialErr *DialError
+	require.ErrorAs(t, err, &dialErr)
+	require.Len(t, dialErr.Attempts, DefaultRetryMaxAttempts)
+	for i, attempt := range dialErr.Attempts {
+		assert.Equal(t, i, attempt.Retry)
+		assert.ErrorIs(t, attempt.Err, syscall.ECONNREFUSED)
+	}
+}
+
+func TestDialRetriesStop(t *testing.T) {
+	var tries int
+	nx := New()
+	nx.OverrideDial(func(ctx context.Context, network, addr string) (net.Conn, error) {
+		tries++
+		return nil, assert.AnError
+	})
+	nx.SetRetryPolicy(&RetryPolicy{MaxAttempts: 5, InitialBackoff: time.Millisecond})
+	_, err := nx.DialContext(context.Background(), "tcp", "127.0.0.1:1")
+	assert.ErrorIs(t, err, assert.AnError)
+	assert.Equal(t, 1, tries, "non-retryable errors shouldn't be retried")
+
+	tries = 0
+	nx.SetRetryPolicy(&RetryPolicy{MaxAttempts: 5, InitialBackoff: time.Second, Retryable: func(err error) bool { return true }})
+	start := time.Now()
+	_, err = nx.DialTimeout("tcp", "127.0.0.1:1", 100*time.Millisecond)
+	assert.Error(t, err)
+	assert.Equal(t, 1, tries, "shouldn't retry if backoff exceeds deadline")
+	assert.True(t, time.Since(start) < time.Second)
+
+	tries = 0
+	nx.SetRetryPolicy(nil)
+	_, _ = nx.DialContext(context.Background(), "tcp", "127.0.0.1:1")
+	assert.Equal(t, 1, tries)
+}
@@ -0,0 +1,168 @@
+package netx
+
+import (
+	"net"
+	"sort"
+)
+
+// This file sorts destination addresses according to RFC 6724 section 6,
+// leaving out the rules that need information we don't have (3, 4 and 7).
+
+const (
+	scopeLinkLocal = 0x2
+	scopeSiteLocal = 0x5
+	scopeGlobal    = 0xe
+)
+
+type policyEntry struct {
+	prefix     *net.IPNet
+	precedence int
+	label      int
+}
+
+// policyTable is the default policy table from RFC 6724 section 2.1, ordered
+// by decreasing prefix length so that the first match is the longest.
+var policyTable = func() []policyEntry {
+	entries := []struct {
+		cidr       string
+		precedence int
+		label      int
+	}{
+		{"::1/128", 50, 0},
+		{"::ffff:0:0/96", 35, 4},
+		{"::/96", 1, 3},
+		{"2001::/32", 5, 5},
+		{"2002::/16", 30, 2},
+		{"3ffe::/16", 1, 12},
+		{"fec0::/10", 1, 11},
+		{"fc00::/7", 3, 13},
+		{"::/0", 40, 1},
+	}
+	table := make([]policyEntry, 0, len(entries))
+	for _, entry := range entries {
+		_, prefix, _ := net.ParseCIDR(entry.cidr)
+		table = append(table, policyEntry{prefix, entry.precedence, entry.label})
+	}
+	return table
+}()
+
+func classifyPolicy(ip net.IP) policyEntry {
+	ip = ip.To16()
+	for _, entry := range policyTable {
+		if entry.prefix.Contains(ip) {
+			return entry
+		}
+	}
+	return policyEntry{}
+}
+
+func classifyScope(ip net.IP) int {
+	if ip.IsLoopback() || ip.IsLinkLocalUnicast() {
+		return scopeLinkLocal
+	}
+	if ip.To4() == nil {
+		if ip.IsMulticast() {
+			return int(ip[1] & 0xf)
+		}
+		// site-local addresses are deprecated but still have their own scope
+		if ip[0] == 0xfe && ip[1]&0xc0 == 0xc0 {
+			return scopeSiteLocal
+		}
+	}
+	return scopeGlobal
+}
+
+func commonPrefixLen(a, b net.IP) int {
+	a, b = a.To16(), b.To16()
+	bits := 0
+	for i := 0; i < net.IPv6len; i++ {
+		x := a[i] ^ b[i]
+		if x == 0 {
+			bits += 8
+			continue
+		}
+		for x&0x80 == 0 {
+			bits++
+			x <<= 1
+		}
+		break
+	}
+	return bits
+}
+
+// sourceIP returns the source address that the system would use to reach dst,
+// or nil if dst is unreachable. Connecting a UDP socket doesn't send anything.
+func sourceIP(dst net.IP) net.IP {
+	conn, err := net.DialUDP("udp", nil, &net.UDPAddr{IP: dst, Port: 9})
+	if err != nil {
+		return nil
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).IP
+}
+
+// sortByRFC6724 sorts ips in place so that the most preferred destination
+// comes first.
+func sortByRFC6724(ips []net.IP) {
+	sortByRFC6724Using(ips, sourceIP)
+}
+
+func sortByRFC6724Using(ips []net.IP, srcFor func(net.IP) net.IP) {
+	type candidate struct {
+		dst, src             net.IP
+		dstPolicy, srcPolicy policyEntry
+		dstScope, srcScope   int
+	}
+	candidates := make([]candidate, len(ips))
+	for i, ip := range ips {
+		c := candidate{dst: ip, src: srcFor(ip), dstPolicy: classifyPolicy(ip), dstScope: classifyScope(ip)}
+		if c.src != nil {
+			c.srcPolicy = classifyPolicy(c.src)
+			c.srcScope = classifyScope(c.src)
+		}
+		candidates[i] = c
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		// Rule 1: avoid unusable destinations
+		if (a.src == nil) != (b.src == nil) {
+			return a.src != nil
+		}
+		if a.src == nil {
+			return false
+		}
+		// Rule 2: prefer matching scope
+		aMatch, bMatch := a.dstScope == a.srcScope, b.dstScope == b.srcScope
+		if aMatch != bMatch {
+			return aMatch
+		}
+		// Rule 5: prefer matching label
+		aMatch, bMatch = a.dstPolicy.label == a.srcPolicy.label, b.dstPolicy.label == b.srcPolicy.label
+		if aMatch != bMatch {
+			return aMatch
+		}
+		// Rule 6: prefer higher precedence
+		if a.dstPolicy.precedence != b.dstPolicy.precedence {
+			return a.dstPolicy.precedence > b.dstPolicy.precedence
+		}
+		// Rule 8: prefer smaller scope
+		if a.dstScope != b.dstScope {
+			return a.dstScope < b.dstScope
+		}
+		// Rule 9: use longest matching prefix. Like Go's own implementation, this
+		// only applies to IPv6, since it's counterproductive for IPv4.
+		if a.dst.To4() == nil && b.dst.To4() == nil {
+			aLen, bLen := commonPrefixLen(a.src, a.dst), commonPrefixLen(b.src, b.dst)
+			if aLen != bLen {
+				return aLen > bLen
+			}
+		}
+		// Rule 10: otherwise, leave the order unchanged
+		return false
+	})
+
+	for i, c := range candidates {
+		ips[i] = c.dst
+	}
+}
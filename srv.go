@@ -0,0 +1,86 @@
+package netx
+
+import (
+	"context"
+	"math/rand"
+	"net"
+	"sort"
+
+	"github.com/getlantern/errors"
+)
+
+// SRVResolver is a Resolver that can also look up SRV records. *net.Resolver
+// implements this interface.
+type SRVResolver interface {
+	Resolver
+
+	// LookupSRV looks up the SRV records for the given service, protocol and
+	// domain name like net.Resolver.LookupSRV does, returning the canonical name
+	// and the records.
+	LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+}
+
+// ResolveSRV resolves SRV records using the configured Resolver. See
+// Netx.ResolveSRV.
+func ResolveSRV(service, proto, name string) (string, []*net.SRV, error) {
+	return defaultNetx.ResolveSRV(service, proto, name)
+}
+
+// ResolveSRV looks up the SRV records for _service._proto.name using the
+// configured Resolver, which along with any resolver middleware must implement
+// SRVResolver. The records are ordered as described by RFC 2782, that is by
+// priority and randomly weighted within each priority, so callers should try
+// them in turn. If service and proto are empty, name is looked up directly.
+func (nx *Netx) ResolveSRV(service, proto, name string) (string, []*net.SRV, error) {
+	resolver, ok := nx.resolverMiddleware.get().(SRVResolver)
+	if !ok {
+		return "", nil, errors.New("Configured resolver does not support SRV lookups")
+	}
+	cname, addrs, err := resolver.LookupSRV(context.Background(), service, proto, name)
+	if err != nil {
+		return "", nil, errors.New("Unable to resolve SRV for %v: %v", name, err)
+	}
+	if len(addrs) == 1 && addrs[0].Target == "." {
+		return "", nil, errors.New("Service %v is not available at %v", service, name)
+	}
+	orderSRV(addrs)
+	return cname, addrs, nil
+}
+
+// orderSRV orders addrs by priority and randomly by weight within each
+// priority, per RFC 2782.
+func orderSRV(addrs []*net.SRV) {
+	sort.SliceStable(addrs, func(i, j int) bool {
+		return addrs[i].Priority < addrs[j].Priority
+	})
+	start := 0
+	for end := 1; end <= len(addrs); end++ {
+		if end == len(addrs) || addrs[end].Priority != addrs[start].Priority {
+			shuffleByWeight(addrs[start:end])
+			start = end
+		}
+	}
+}
+
+// shuffleByWeight orders addrs, which all have the same priority, so that each
+// one comes first with a probability proportional to its weight. Records with
+// zero weight end up last.
+func shuffleByWeight(addrs []*net.SRV) {
+	sum := 0
+	for _, addr := range addrs {
+		sum += int(addr.Weight)
+	}
+	for sum > 0 && len(addrs) > 1 {
+		n := rand.Intn(sum)
+		running := 0
+		for i := range addrs {
+			running += int(addrs[i].Weight)
+			if running > n {
+				addrs[0], addrs[i] = addrs[i], addrs[0]
+				break
+			}
+		}
+		sum -= int(addrs[0].Weight)
+		addrs = addrs[1:]
+	}
+}
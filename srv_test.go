@@ -0,0 +1,91 @@
+package netx
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type srvTestResolver struct {
+	recordingResolver
+	addrs []*net.SRV
+}
+
+func (r *srvTestResolver) LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	addrs := make([]*net.SRV, 0, len(r.addrs))
+	for _, addr := range r.addrs {
+		copied := *addr
+		addrs = append(addrs, &copied)
+	}
+	return "_" + service + "._" + proto + "." + name + ".", addrs, nil
+}
+
+func TestResolveSRV(t *testing.T) {
+	nx := New()
+	nx.OverrideResolver(&srvTestResolver{addrs: []*net.SRV{
+		{Target: "backup.example.", Port: 1, Priority: 20, Weight: 0},
+		{Target: "heavy.example.", Port: 2, Priority: 10, Weight: 90},
+		{Target: "light.example.", Port: 3, Priority: 10, Weight: 10},
+		{Target: "unweighted.example.", Port: 4, Priority: 10, Weight: 0},
+	}})
+
+	heavyFirst := 0
+	for i := 0; i < 1000; i++ {
+		cname, addrs, err := nx.ResolveSRV("xmpp", "tcp", "example.com")
+		require.NoError(t, err)
+		assert.Equal(t, "_xmpp._tcp.example.com.", cname)
+		require.Len(t, addrs, 4)
+		if addrs[0].Target == "heavy.example." {
+			heavyFirst++
+		}
+		assert.Equal(t, "unweighted.example.", addrs[2].Target, "zero weight should come last within its priority")
+		assert.Equal(t, "backup.example.", addrs[3].Target, "lower priority should come last")
+	}
+	assert.InDelta(t, 900, heavyFirst, 100)
+
+	// the cache passes SRV lookups through
+	nx.EnableDNSCache(nil)
+	_, addrs, err := nx.ResolveSRV("xmpp", "tcp", "example.com")
+	require.NoError(t, err)
+	assert.Len(t, addrs, 4)
+
+	nx.OverrideResolver(&srvTestResolver{addrs: []*net.SRV{{Target: "."}}})
+	_, _, err = nx.ResolveSRV("xmpp", "tcp", "example.com")
+	assert.Error(t, err, "a target of . means the service isn't available")
+
+	nx.OverrideResolveIPs(func(host string) ([]net.IP, error) { return nil, nil })
+	_, _, err = nx.ResolveSRV("xmpp", "tcp", "example.com")
+	assert.Error(t, err)
+}
+
+func TestResolveIPAndUnixAddr(t *testing.T) {
+	nx := New()
+	nx.OverrideResolveIPs(func(host string) ([]net.IP, error) {
+		return parseIPs("1.1.1.1", "2001:db8::1"), nil
+	})
+	nx.SetHostsOverride(map[string][]net.IP{"pinned.example": parseIPs("2.2.2.2")})
+
+	addr, err := nx.ResolveIPAddr("ip", "example.com")
+	require.NoError(t, err)
+	assert.Equal(t, "1.1.1.1", addr.String())
+	addr, err = nx.ResolveIPAddr("ip6", "example.com")
+	require.NoError(t, err)
+	assert.Equal(t, "2001:db8::1", addr.String())
+	addr, err = nx.ResolveIPAddr("", "pinned.example")
+	require.NoError(t, err)
+	assert.Equal(t, "2.2.2.2", addr.String())
+	addr, err = nx.ResolveIPAddr("ip6", "fe80::1%eth0")
+	require.NoError(t, err)
+	assert.Equal(t, "eth0", addr.Zone)
+	_, err = nx.ResolveIPAddr("tcp", "example.com")
+	assert.Error(t, err)
+
+	unixAddr, err := nx.ResolveUnixAddr("unixgram", "/tmp/socket")
+	require.NoError(t, err)
+	assert.Equal(t, &net.UnixAddr{Name: "/tmp/socket", Net: "unixgram"}, unixAddr)
+	_, err = nx.ResolveUnixAddr("tcp", "/tmp/socket")
+	assert.Error(t, err)
+}
@@ -0,0 +1,123 @@
+package netx
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/getlantern/errors"
+)
+
+// DialUDPContext dials a UDP connection using the configured dialUDP function.
+// See Netx.DialUDPContext.
+func DialUDPContext(ctx context.Context, network string, laddr, raddr *net.UDPAddr) (*net.UDPConn, error) {
+	return defaultNetx.DialUDPContext(ctx, network, laddr, raddr)
+}
+
+// DialUDPContext dials a UDP connection using the configured dialUDP function,
+// with the given context. Like DialContext, it dials IPv4 addresses via IPv6
+// using the NAT64 prefix if there is one, falling back to the original address.
+func (nx *Netx) DialUDPContext(ctx context.Context, network string, laddr, raddr *net.UDPAddr) (*net.UDPConn, error) {
+	dialer := nx.getDialUDP()
+	addr := raddr.String()
+	target := raddr
+	if prefix := nx.getNAT64Prefix(); prefix != nil && raddr != nil && network != "udp4" {
+		if converted := convertAddressDNS64(prefix, addr); converted != addr {
+			if synthesized, err := net.ResolveUDPAddr("udp6", converted); err == nil {
+				target = synthesized
+			}
+		}
+	}
+
+	conn, attempt := dialUDPAndRecord(ctx, dialer, network, laddr, addr, target, target != raddr)
+	if attempt.Err == nil {
+		return conn, nil
+	}
+	dialErr := &DialError{Network: network, Addr: addr, Attempts: []DialAttempt{attempt}}
+	// we might have a prefix but no ipv6 connectivity, so try ipv4 as fallback
+	if target != raddr {
+		conn, attempt = dialUDPAndRecord(ctx, dialer, network, laddr, addr, raddr, false)
+		if attempt.Err == nil {
+			return conn, nil
+		}
+		dialErr.Attempts = append(dialErr.Attempts, attempt)
+	}
+	nx.refreshNAT64Prefix()
+	return nil, dialErr
+}
+
+// dialUDPAndRecord dials raddr using dialer and records the attempt.
+func dialUDPAndRecord(ctx context.Context, dialer DialUDPFunc, network string, laddr *net.UDPAddr, originalAddr string, raddr *net.UDPAddr, synthesized bool) (*net.UDPConn, DialAttempt) {
+	start := time.Now()
+	conn, err := dialer(ctx, network, laddr, raddr)
+	return conn, DialAttempt{
+		Network:      network,
+		OriginalAddr: originalAddr,
+		Addr:         raddr.String(),
+		Synthesized:  synthesized,
+		Duration:     time.Since(start),
+		Err:          err,
+		Timeout:      err != nil && IsTimeout(err),
+	}
+}
+
+// ListenPacketContext listens using the configured listenPacket function. See
+// Netx.ListenPacketContext.
+func ListenPacketContext(ctx context.Context, network string, addr string) (net.PacketConn, error) {
+	return defaultNetx.ListenPacketContext(ctx, network, addr)
+}
+
+// ListenPacketContext listens on the given local address like
+// net.ListenConfig.ListenPacket, using the configured listenPacket function.
+func (nx *Netx) ListenPacketContext(ctx context.Context, network string, addr string) (net.PacketConn, error) {
+	return nx.getListenPacket()(ctx, network, addr)
+}
+
+// OverrideDialUDPContext overrides the global dialUDP function.
+func OverrideDialUDPContext(dialFN func(ctx context.Context, network string, laddr, raddr *net.UDPAddr) (*net.UDPConn, error)) {
+	defaultNetx.OverrideDialUDPContext(dialFN)
+}
+
+// OverrideDialUDPContext overrides this Netx's dialUDP function, which both
+// DialUDP and DialUDPContext use.
+func (nx *Netx) OverrideDialUDPContext(dialFN func(ctx context.Context, network string, laddr, raddr *net.UDPAddr) (*net.UDPConn, error)) {
+	nx.dialUDPMiddleware.setBase(dialFN)
+}
+
+// OverrideListenPacket overrides the global listenPacket function.
+func OverrideListenPacket(listenFN func(ctx context.Context, network string, addr string) (net.PacketConn, error)) {
+	defaultNetx.OverrideListenPacket(listenFN)
+}
+
+// OverrideListenPacket overrides this Netx's listenPacket function, which both
+// ListenUDP and ListenPacketContext use.
+func (nx *Netx) OverrideListenPacket(listenFN func(ctx context.Context, network string, addr string) (net.PacketConn, error)) {
+	nx.listenMiddleware.setBase(listenFN)
+}
+
+// dialUDPContext is the default dialUDP function.
+func dialUDPContext(ctx context.Context, network string, laddr, raddr *net.UDPAddr) (*net.UDPConn, error) {
+	switch network {
+	case "udp", "udp4", "udp6":
+	default:
+		return nil, &net.OpError{Op: "dial", Net: network, Err: net.UnknownNetworkError(network)}
+	}
+	if raddr == nil {
+		return nil, &net.OpError{Op: "dial", Net: network, Err: errors.New("missing address")}
+	}
+	var d net.Dialer
+	if laddr != nil {
+		d.LocalAddr = laddr
+	}
+	conn, err := d.DialContext(ctx, network, raddr.String())
+	if err != nil {
+		return nil, err
+	}
+	return conn.(*net.UDPConn), nil
+}
+
+// listenPacketContext is the default listenPacket function.
+func listenPacketContext(ctx context.Context, network string, addr string) (net.PacketConn, error) {
+	var lc net.ListenConfig
+	return lc.ListenPacket(ctx, network, addr)
+}
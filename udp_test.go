@@ -0,0 +1,104 @@
+package netx
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDialUDPContextNAT64(t *testing.T) {
+	nx := New()
+	prefix, _ := ParseNAT64Prefix("64:ff9b::/96")
+	nx.SetNAT64Prefix(prefix)
+	var dialed []string
+	nx.OverrideDialUDPContext(func(ctx context.Context, network string, laddr, raddr *net.UDPAddr) (*net.UDPConn, error) {
+		dialed = append(dialed, raddr.String())
+		return nil, &net.OpError{Op: "dial", Net: network, Err: assert.AnError}
+	})
+
+	raddr := &net.UDPAddr{IP: net.ParseIP("93.184.216.34"), Port: 53}
+	_, err := nx.DialUDPContext(context.Background(), "udp", nil, raddr)
+	require.Error(t, err)
+	assert.Equal(t, []string{"[64:ff9b::5db8:d822]:53", "93.184.216.34:53"}, dialed)
+	var dialErr *DialError
+	require.True(t, errors.As(err, &dialErr))
+	require.Len(t, dialErr.Attempts, 2)
+	assert.True(t, dialErr.Attempts[0].Synthesized)
+	assert.Equal(t, "93.184.216.34:53", dialErr.Attempts[0].OriginalAddr)
+	assert.False(t, dialErr.Attempts[1].Synthesized)
+
+	dialed = nil
+	_, _ = nx.DialUDPContext(context.Background(), "udp4", nil, raddr)
+	assert.Equal(t, []string{"93.184.216.34:53"}, dialed, "udp4 shouldn't be synthesized")
+
+	// the legacy DialUDP goes through the same path
+	dialed = nil
+	_, _ = nx.DialUDP("udp", nil, raddr)
+	assert.Equal(t, []string{"[64:ff9b::5db8:d822]:53", "93.184.216.34:53"}, dialed)
+}
+
+func TestDialUDPContextCanceled(t *testing.T) {
+	nx := New()
+	nx.OverrideDialUDPContext(func(ctx context.Context, network string, laddr, raddr *net.UDPAddr) (*net.UDPConn, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := nx.DialUDPContext(ctx, "udp", nil, &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 53})
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestListenPacketContext(t *testing.T) {
+	nx := New()
+	var listened string
+	nx.UseListenPacketMiddleware(func(next ListenPacketFunc) ListenPacketFunc {
+		return func(ctx context.Context, network string, addr string) (net.PacketConn, error) {
+			listened = addr
+			return next(ctx, network, addr)
+		}
+	})
+	pc, err := nx.ListenPacketContext(context.Background(), "udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer pc.Close()
+	assert.Equal(t, "127.0.0.1:0", listened)
+
+	conn, err := nx.DialUDPContext(context.Background(), "udp4", nil, pc.LocalAddr().(*net.UDPAddr))
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("hello"))
+	require.NoError(t, err)
+	b := make([]byte, 5)
+	n, _, err := pc.ReadFrom(b)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(b[:n]))
+}
+
+func TestLegacyUDPOverrides(t *testing.T) {
+	nx := New()
+	var dialed, listened int
+	nx.OverrideDialUDP(func(network string, laddr, raddr *net.UDPAddr) (*net.UDPConn, error) {
+		dialed++
+		return net.DialUDP(network, laddr, raddr)
+	})
+	nx.OverrideListenUDP(func(network string, laddr *net.UDPAddr) (*net.UDPConn, error) {
+		listened++
+		return net.ListenUDP(network, laddr)
+	})
+
+	pc, err := nx.ListenPacketContext(context.Background(), "udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer pc.Close()
+	conn, err := nx.DialUDPContext(context.Background(), "udp4", nil, pc.LocalAddr().(*net.UDPAddr))
+	require.NoError(t, err)
+	conn.Close()
+	assert.Equal(t, 1, listened)
+	assert.Equal(t, 1, dialed)
+
+	_, err = nx.ListenPacketContext(context.Background(), "ip4:icmp", "")
+	assert.Error(t, err, "legacy ListenUDP override only supports UDP")
+}